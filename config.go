@@ -0,0 +1,348 @@
+// Copyright 2021 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ConfigError is returned when a setting found in the environment, in
+// a context file or in the query parameters of a URL is not valid.
+type ConfigError struct {
+	// Source is "environment", the path of the context file or "url".
+	Source string
+
+	// Setting is the environment variable, the key of the context file
+	// or the query parameter.
+	Setting string
+
+	// Err is the reason the setting is not valid.
+	Err error
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("nats: invalid %s in %s: %s", e.Setting, e.Source,
+		strings.TrimPrefix(e.Err.Error(), "nats: "))
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// envSettings are the environment variables for the settings of a
+// context file.
+var envSettings = map[string]string{
+	"url":                  "NATS_URL",
+	"name":                 "NATS_NAME",
+	"timeout":              "NATS_TIMEOUT",
+	"user":                 "NATS_USER",
+	"password":             "NATS_PASSWORD",
+	"token":                "NATS_TOKEN",
+	"creds":                "NATS_CREDS",
+	"nkey":                 "NATS_NKEY",
+	"cert":                 "NATS_CERT",
+	"key":                  "NATS_KEY",
+	"ca":                   "NATS_CA",
+	"inbox_prefix":         "NATS_INBOX_PREFIX",
+	"jetstream_api_prefix": "NATS_JS_API_PREFIX",
+	"jetstream_domain":     "NATS_JS_DOMAIN",
+}
+
+// connectConfig holds the settings read from the environment or from a
+// context file, by context file key.
+type connectConfig struct {
+	src  string
+	env  bool
+	vals map[string]string
+}
+
+// OptionsFromEnv returns the default options updated with the settings
+// of the following environment variables, if set:
+//
+//	NATS_URL            comma separated list of server URLs
+//	NATS_NAME           client name
+//	NATS_TIMEOUT        connect timeout, such as "5s"
+//	NATS_USER           user name
+//	NATS_PASSWORD       password
+//	NATS_TOKEN          authentication token
+//	NATS_CREDS          credentials file
+//	NATS_NKEY           nkey seed file
+//	NATS_CERT           client certificate file
+//	NATS_KEY            client certificate key file
+//	NATS_CA             root CA file
+//	NATS_INBOX_PREFIX   inbox prefix, see CustomInboxPrefix
+//	NATS_JS_API_PREFIX  JetStream API prefix, see APIPrefix
+//	NATS_JS_DOMAIN      JetStream domain, see Domain
+//
+// A *ConfigError is returned if a setting is not valid.
+func OptionsFromEnv() (Options, error) {
+	c := &connectConfig{src: "environment", env: true, vals: make(map[string]string)}
+	for key, env := range envSettings {
+		c.vals[key] = os.Getenv(env)
+	}
+	return c.options()
+}
+
+// OptionsFromContext returns the default options updated with the settings
+// of a context file, as created by the NATS command line tool. The name
+// is the one of a context in the "nats/context" directory of the user
+// configuration directory, or the path of a ".json" file. If empty, the
+// NATS_CONTEXT environment variable, or else the selected context, is used.
+// The settings are named as the environment variables of OptionsFromEnv,
+// in lower case and without the "NATS_" prefix, except for "jetstream_domain"
+// and "jetstream_api_prefix".
+// A *ConfigError is returned if a setting is not valid.
+func OptionsFromContext(name string) (Options, error) {
+	path, err := contextPath(name)
+	if err != nil {
+		return Options{}, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Options{}, fmt.Errorf("nats: error reading context: %v", err)
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return Options{}, &ConfigError{Source: path, Setting: "context", Err: err}
+	}
+	c := &connectConfig{src: path, vals: make(map[string]string)}
+	for key := range envSettings {
+		v, ok := raw[key]
+		if !ok || v == nil {
+			continue
+		}
+		s, ok := v.(string)
+		if !ok {
+			return Options{}, c.invalid(key, errors.New("not a string"))
+		}
+		c.vals[key] = s
+	}
+	if nsc, _ := raw["nsc"].(string); nsc != _EMPTY_ {
+		return Options{}, c.invalid("nsc", errors.New("nsc lookups are not supported"))
+	}
+	return c.options()
+}
+
+// ConnectFromEnv connects with the options returned by OptionsFromEnv,
+// updated with the given options.
+func ConnectFromEnv(options ...Option) (*Conn, error) {
+	opts, err := OptionsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return connectWith(opts, options)
+}
+
+// ConnectFromContext connects with the options returned by
+// OptionsFromContext, updated with the given options.
+func ConnectFromContext(name string, options ...Option) (*Conn, error) {
+	opts, err := OptionsFromContext(name)
+	if err != nil {
+		return nil, err
+	}
+	return connectWith(opts, options)
+}
+
+func connectWith(opts Options, options []Option) (*Conn, error) {
+	for _, opt := range options {
+		if opt != nil {
+			if err := opt(&opts); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return opts.Connect()
+}
+
+// contextPath returns the path of the context file for name.
+func contextPath(name string) (string, error) {
+	if name == _EMPTY_ {
+		name = os.Getenv("NATS_CONTEXT")
+	}
+	if strings.HasSuffix(name, ".json") {
+		return name, nil
+	}
+	dir, err := natsConfigDir()
+	if err != nil {
+		return _EMPTY_, err
+	}
+	if name == _EMPTY_ {
+		b, err := ioutil.ReadFile(filepath.Join(dir, "context.txt"))
+		if os.IsNotExist(err) {
+			return _EMPTY_, ErrNoContext
+		} else if err != nil {
+			return _EMPTY_, fmt.Errorf("nats: error reading selected context: %v", err)
+		}
+		if name = strings.TrimSpace(string(b)); name == _EMPTY_ {
+			return _EMPTY_, ErrNoContext
+		}
+	}
+	if strings.ContainsAny(name, `/\`) || strings.Contains(name, "..") {
+		return _EMPTY_, fmt.Errorf("nats: invalid context name %q", name)
+	}
+	return filepath.Join(dir, "context", name+".json"), nil
+}
+
+// natsConfigDir returns the configuration directory of the NATS command
+// line tool.
+func natsConfigDir() (string, error) {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == _EMPTY_ {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return _EMPTY_, fmt.Errorf("nats: error locating context directory: %v", err)
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "nats"), nil
+}
+
+// invalid returns the error for the setting of the given key.
+func (c *connectConfig) invalid(key string, err error) error {
+	setting := key
+	if c.env {
+		setting = envSettings[key]
+	}
+	return &ConfigError{Source: c.src, Setting: setting, Err: err}
+}
+
+// options returns the default options updated with the settings.
+func (c *connectConfig) options() (Options, error) {
+	o := GetDefaultOptions()
+	if u := c.vals["url"]; u != _EMPTY_ {
+		servers, err := urlOptions(u, &o)
+		if err != nil {
+			return o, err
+		}
+		o.Servers = processUrlString(servers)
+		nc := &Conn{Opts: o}
+		for _, s := range o.Servers {
+			if _, err := nc.parseServerURL(s); err != nil {
+				return o, c.invalid("url", err)
+			}
+		}
+	}
+	if err := c.apply(&o); err != nil {
+		return o, err
+	}
+	return o, nil
+}
+
+// apply updates the options with the settings that are set.
+func (c *connectConfig) apply(o *Options) error {
+	v := c.vals
+	apply := func(key string, opt Option) error {
+		if err := opt(o); err != nil {
+			return c.invalid(key, err)
+		}
+		return nil
+	}
+
+	if v["name"] != _EMPTY_ {
+		o.Name = v["name"]
+	}
+	if t := v["timeout"]; t != _EMPTY_ {
+		d, err := time.ParseDuration(t)
+		if err == nil && d <= 0 {
+			err = ErrBadTimeout
+		}
+		if err != nil {
+			return c.invalid("timeout", err)
+		}
+		o.Timeout = d
+	}
+
+	// Authentication.
+	switch {
+	case v["password"] != _EMPTY_ && v["user"] == _EMPTY_:
+		return c.invalid("password", errors.New("no user set"))
+	case v["token"] != _EMPTY_ && v["user"] != _EMPTY_:
+		return c.invalid("token", errors.New("user and token can not both be set"))
+	case v["creds"] != _EMPTY_ && v["nkey"] != _EMPTY_:
+		return c.invalid("nkey", ErrNkeyAndUser)
+	}
+	if v["user"] != _EMPTY_ {
+		o.User, o.Password = v["user"], v["password"]
+	}
+	if v["token"] != _EMPTY_ {
+		o.Token = v["token"]
+	}
+	if creds := v["creds"]; creds != _EMPTY_ {
+		// Fail now rather than on every connect attempt.
+		if _, err := userFromFile(creds); err != nil {
+			return c.invalid("creds", err)
+		}
+		if err := apply("creds", UserCredentials(creds)); err != nil {
+			return err
+		}
+	}
+	if seed := v["nkey"]; seed != _EMPTY_ {
+		opt, err := NkeyOptionFromSeed(seed)
+		if err != nil {
+			return c.invalid("nkey", err)
+		}
+		if err := apply("nkey", opt); err != nil {
+			return err
+		}
+	}
+
+	// TLS.
+	switch cert, key := v["cert"], v["key"]; {
+	case cert != _EMPTY_ && key == _EMPTY_:
+		return c.invalid("key", errors.New("required by client certificate"))
+	case cert == _EMPTY_ && key != _EMPTY_:
+		return c.invalid("cert", errors.New("required by client certificate key"))
+	case cert != _EMPTY_:
+		if err := apply("cert", ClientCert(cert, key)); err != nil {
+			return err
+		}
+	}
+	if ca := v["ca"]; ca != _EMPTY_ {
+		if err := apply("ca", RootCAs(ca)); err != nil {
+			return err
+		}
+	}
+
+	if pre := v["inbox_prefix"]; pre != _EMPTY_ {
+		if err := apply("inbox_prefix", CustomInboxPrefix(pre)); err != nil {
+			return err
+		}
+	}
+
+	// JetStream.
+	pre, domain := v["jetstream_api_prefix"], v["jetstream_domain"]
+	if pre != _EMPTY_ && domain != _EMPTY_ {
+		return c.invalid("jetstream_domain", errors.New("jetstream api prefix and domain can not both be set"))
+	}
+	if pre != _EMPTY_ {
+		if badSubject(strings.TrimSuffix(pre, ".")) || strings.ContainsAny(pre, "*>") {
+			return c.invalid("jetstream_api_prefix", ErrJetStreamBadPre)
+		}
+		o.JetStreamAPIPrefix = pre
+	}
+	if domain != _EMPTY_ {
+		if err := Domain(domain).configureJSContext(&jsOpts{}); err != nil {
+			return c.invalid("jetstream_domain", err)
+		}
+		o.JetStreamDomain = domain
+	}
+	return nil
+}
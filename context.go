@@ -18,6 +18,42 @@ import (
 	"reflect"
 )
 
+// PublishWithContext publishes the data argument to the given subject,
+// waiting for room in the outbound buffer, if limited by the
+// MaxOutboundBytes option, until the context is done.
+func (nc *Conn) PublishWithContext(ctx context.Context, subj string, data []byte) error {
+	if ctx == nil {
+		return ErrInvalidContext
+	}
+	return nc.publishContext(ctx, subj, _EMPTY_, nil, data)
+}
+
+// PublishMsgWithContext publishes the Msg structure, waiting for room in
+// the outbound buffer, if limited by the MaxOutboundBytes option, until
+// the context is done.
+func (nc *Conn) PublishMsgWithContext(ctx context.Context, m *Msg) error {
+	if ctx == nil {
+		return ErrInvalidContext
+	}
+	if m == nil {
+		return ErrInvalidMsg
+	}
+	var hdr []byte
+	var err error
+
+	if len(m.Header) > 0 {
+		if !nc.info.Headers {
+			return ErrHeadersNotSupported
+		}
+
+		hdr, err = m.headerBytes()
+		if err != nil {
+			return err
+		}
+	}
+	return nc.publishContext(ctx, m.Subject, m.Reply, hdr, m.Data)
+}
+
 // RequestMsgWithContext takes a context, a subject and payload
 // in bytes and request expecting a single response.
 func (nc *Conn) RequestMsgWithContext(ctx context.Context, msg *Msg) (*Msg, error) {
@@ -62,7 +98,7 @@ func (nc *Conn) requestWithContext(ctx context.Context, subj string, hdr, data [
 
 	// If user wants the old style.
 	if nc.useOldRequestStyle() {
-		m, err = nc.oldRequestWithContext(ctx, subj, hdr, data)
+		m, err = nc.oldRequestWithContext(ctx, _EMPTY_, subj, hdr, data)
 	} else {
 		mch, token, err := nc.createNewRequestAndSend(subj, hdr, data)
 		if err != nil {
@@ -84,18 +120,19 @@ func (nc *Conn) requestWithContext(ctx context.Context, subj string, hdr, data [
 		}
 	}
 	// Check for no responder status.
-	if err == nil && len(m.Data) == 0 && m.Header.Get(statusHdr) == noResponders {
+	if err == nil && len(m.Data) == 0 && m.headerGet(statusHdr) == noResponders {
 		m, err = nil, ErrNoResponders
 	}
 	return m, err
 }
 
 // oldRequestWithContext utilizes inbox and subscription per request.
-func (nc *Conn) oldRequestWithContext(ctx context.Context, subj string, hdr, data []byte) (*Msg, error) {
-	inbox := NewInbox()
+// The inbox is in the namespace ns, if any.
+func (nc *Conn) oldRequestWithContext(ctx context.Context, ns, subj string, hdr, data []byte) (*Msg, error) {
+	inbox := ns + nc.newInbox()
 	ch := make(chan *Msg, RequestChanLen)
 
-	s, err := nc.subscribe(inbox, _EMPTY_, nil, ch, true, nil)
+	s, err := nc.nsSubscribe(ns, inbox, _EMPTY_, nil, ch, true, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -133,11 +170,21 @@ func (s *Subscription) NextMsgWithContext(ctx context.Context) (*Msg, error) {
 
 	// snapshot
 	mch := s.mch
+	resumed := s.resumed
 	s.mu.Unlock()
 
 	var ok bool
 	var msg *Msg
 
+	// Wait for a paused subscription to be resumed first.
+	if resumed != nil {
+		select {
+		case <-resumed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
 	// If something is available right away, let's optimize that case.
 	select {
 	case msg, ok = <-mch:
@@ -241,3 +288,178 @@ func (c *EncodedConn) RequestWithContext(ctx context.Context, subject string, v
 
 	return nil
 }
+
+// SubscribeWithContext is like Subscribe, with the subscription removed
+// once ctx is done. See UnsubscribeOnDone.
+func (nc *Conn) SubscribeWithContext(ctx context.Context, subj string, cb MsgHandler) (*Subscription, error) {
+	if ctx == nil {
+		return nil, ErrInvalidContext
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	s, err := nc.Subscribe(subj, cb)
+	return bindContext(ctx, s, err)
+}
+
+// QueueSubscribeWithContext is like QueueSubscribe, with the subscription
+// removed once ctx is done. See UnsubscribeOnDone.
+func (nc *Conn) QueueSubscribeWithContext(ctx context.Context, subj, queue string, cb MsgHandler) (*Subscription, error) {
+	if ctx == nil {
+		return nil, ErrInvalidContext
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	s, err := nc.QueueSubscribe(subj, queue, cb)
+	return bindContext(ctx, s, err)
+}
+
+// SubscribeSyncWithContext is like SubscribeSync, with the subscription
+// removed once ctx is done. See UnsubscribeOnDone.
+func (nc *Conn) SubscribeSyncWithContext(ctx context.Context, subj string) (*Subscription, error) {
+	if ctx == nil {
+		return nil, ErrInvalidContext
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	s, err := nc.SubscribeSync(subj)
+	return bindContext(ctx, s, err)
+}
+
+// QueueSubscribeSyncWithContext is like QueueSubscribeSync, with the
+// subscription removed once ctx is done. See UnsubscribeOnDone.
+func (nc *Conn) QueueSubscribeSyncWithContext(ctx context.Context, subj, queue string) (*Subscription, error) {
+	if ctx == nil {
+		return nil, ErrInvalidContext
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	s, err := nc.QueueSubscribeSync(subj, queue)
+	return bindContext(ctx, s, err)
+}
+
+// bindContext unsubscribes s once ctx is done, unless err is not nil.
+func bindContext(ctx context.Context, s *Subscription, err error) (*Subscription, error) {
+	if err != nil {
+		return nil, err
+	}
+	if err := s.UnsubscribeOnDone(ctx, false); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// UnsubscribeOnDone binds the lifetime of the subscription to ctx: once
+// ctx is done, the subscription is removed with Unsubscribe, or with Drain
+// if drain is true, unless it was already. Errors of either are reported
+// to the async error handler.
+func (s *Subscription) UnsubscribeOnDone(ctx context.Context, drain bool) error {
+	if ctx == nil {
+		return ErrInvalidContext
+	}
+	if s == nil {
+		return ErrBadSubscription
+	}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrBadSubscription
+	}
+	done := s.closedChan()
+	nc := s.conn
+	s.mu.Unlock()
+
+	go func() {
+		select {
+		case <-done:
+			return
+		case <-ctx.Done():
+		}
+		// Both may be ready, in which case select picks either.
+		select {
+		case <-done:
+			return
+		default:
+		}
+		var err error
+		if drain {
+			err = s.Drain()
+		} else {
+			err = s.Unsubscribe()
+		}
+		// The subscription may still be closed meanwhile.
+		if err != nil && err != ErrConnectionClosed && err != ErrBadSubscription {
+			nc.mu.Lock()
+			if nc.Opts.AsyncErrorCB != nil {
+				nc.ach.push(func() { nc.Opts.AsyncErrorCB(nc, s, err) })
+			}
+			nc.mu.Unlock()
+		}
+	}()
+	return nil
+}
+
+// Messages returns a channel on which the messages of a synchronous
+// subscription are delivered, for use in place of a loop around
+// NextMsgWithContext. The channel is closed once the subscription is
+// unsubscribed, drained, reached its AutoUnsubscribe limit, or the
+// connection is closed, or else once ctx is done. Slow consumer errors
+// are reported to the async error handler only.
+//
+// At most one message is taken from the subscription ahead of the channel
+// being read, and only one channel is to be used at a time. The channel
+// is to be read until closed, or ctx canceled.
+func (s *Subscription) Messages(ctx context.Context) (<-chan *Msg, error) {
+	if ctx == nil {
+		return nil, ErrInvalidContext
+	}
+	if s == nil {
+		return nil, ErrBadSubscription
+	}
+	s.mu.Lock()
+	typ, closed := s.typ, s.closed
+	s.mu.Unlock()
+	if typ != SyncSubscription {
+		return nil, ErrSyncSubRequired
+	}
+	if closed {
+		return nil, ErrBadSubscription
+	}
+
+	ch := make(chan *Msg)
+	go func() {
+		defer close(ch)
+		for {
+			msg, err := s.NextMsgWithContext(ctx)
+			if err == ErrSlowConsumer {
+				continue
+			}
+			if err != nil {
+				return
+			}
+			// Messages taken before the subscription is closed, such as
+			// while draining, are still delivered.
+			select {
+			case ch <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// closedChan returns a channel closed once the subscription is.
+// Subscription lock is assumed held.
+func (s *Subscription) closedChan() <-chan struct{} {
+	if s.done == nil {
+		s.done = make(chan struct{})
+		if s.closed {
+			close(s.done)
+		}
+	}
+	return s.done
+}
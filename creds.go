@@ -0,0 +1,317 @@
+// Copyright 2021 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNoUserJWT  = errors.New("nats: connection not authenticated with a user jwt")
+	ErrBadUserJWT = errors.New("nats: invalid user jwt")
+)
+
+// DefaultCredentialsExpiryWarning is how long before the user JWT expires
+// the CredentialsExpiringCB and CredentialsRefreshCB are invoked, unless
+// set otherwise.
+const DefaultCredentialsExpiryWarning = time.Minute
+
+// CredentialsExpiringHandler is invoked when the user JWT the connection
+// authenticated with is about to expire.
+type CredentialsExpiringHandler func(nc *Conn, expires time.Time)
+
+// CredentialsRefreshHandler is invoked when the user JWT is about to
+// expire and should renew the credentials, for instance by updating the
+// credentials file. Once it returns without error, the connection
+// re-authenticates with the new credentials.
+type CredentialsRefreshHandler func(nc *Conn) error
+
+// SubjectPermission lists the subjects a user is allowed or denied to
+// publish or subscribe to.
+type SubjectPermission struct {
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+}
+
+// UserClaims are the claims of the user JWT the connection authenticated
+// with. The signature is not verified, this is left to the server.
+type UserClaims struct {
+	ID      string
+	Name    string
+	Subject string
+	// Issuer is the account, or account signing key, that issued the JWT.
+	Issuer string
+	// IssuerAccount is set if the JWT was issued by a signing key.
+	IssuerAccount string
+	IssuedAt      time.Time
+	// Expires is zero if the JWT does not expire.
+	Expires time.Time
+	Pub     SubjectPermission
+	Sub     SubjectPermission
+	// AllowResponses is true if the user may publish to the reply
+	// subject of the requests it receives, whatever Pub says.
+	AllowResponses bool
+}
+
+// jwtClaims is the payload of a user JWT, version 1 and 2.
+type jwtClaims struct {
+	ID       string `json:"jti"`
+	IssuedAt int64  `json:"iat"`
+	Issuer   string `json:"iss"`
+	Name     string `json:"name"`
+	Subject  string `json:"sub"`
+	Expires  int64  `json:"exp"`
+	Type     string `json:"type"`
+	Nats     struct {
+		Pub           SubjectPermission `json:"pub"`
+		Sub           SubjectPermission `json:"sub"`
+		Resp          json.RawMessage   `json:"resp"`
+		IssuerAccount string            `json:"issuer_account"`
+		Type          string            `json:"type"`
+	} `json:"nats"`
+}
+
+// decodeUserJWT returns the claims of a user JWT.
+func decodeUserJWT(token string) (*UserClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrBadUserJWT
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadUserJWT, err)
+	}
+	var c jwtClaims
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadUserJWT, err)
+	}
+	// Version 1 has the type at the top level.
+	typ := c.Nats.Type
+	if typ == _EMPTY_ {
+		typ = c.Type
+	}
+	if typ != "user" {
+		return nil, fmt.Errorf("%w: not a user jwt", ErrBadUserJWT)
+	}
+	uc := &UserClaims{
+		ID:            c.ID,
+		Name:          c.Name,
+		Subject:       c.Subject,
+		Issuer:        c.Issuer,
+		IssuerAccount: c.Nats.IssuerAccount,
+		Pub:           c.Nats.Pub,
+		Sub:           c.Nats.Sub,
+	}
+	if resp := string(c.Nats.Resp); resp != _EMPTY_ && resp != "null" {
+		uc.AllowResponses = true
+	}
+	if c.IssuedAt > 0 {
+		uc.IssuedAt = time.Unix(c.IssuedAt, 0)
+	}
+	if c.Expires > 0 {
+		uc.Expires = time.Unix(c.Expires, 0)
+	}
+	return uc, nil
+}
+
+// CredentialsExpiring is an Option to set the callback invoked when the
+// user JWT is about to expire, warning before it does.
+func CredentialsExpiring(cb CredentialsExpiringHandler, warning time.Duration) Option {
+	return func(o *Options) error {
+		o.CredentialsExpiringCB = cb
+		o.CredentialsExpiryWarning = warning
+		return nil
+	}
+}
+
+// CredentialsRefresh is an Option to set the callback invoked to renew
+// the credentials when the user JWT is about to expire.
+// See CredentialsRefreshCB Option for more details.
+func CredentialsRefresh(cb CredentialsRefreshHandler) Option {
+	return func(o *Options) error {
+		o.CredentialsRefreshCB = cb
+		return nil
+	}
+}
+
+// WatchCredentials is an Option to check the credentials file given to
+// UserCredentials at the given interval, and re-authenticate when the
+// user JWT in it changes.
+func WatchCredentials(interval time.Duration) Option {
+	return func(o *Options) error {
+		o.CredentialsWatchInterval = interval
+		return nil
+	}
+}
+
+// UserClaims returns the claims of the user JWT the connection
+// authenticated with.
+func (nc *Conn) UserClaims() (*UserClaims, error) {
+	nc.mu.RLock()
+	defer nc.mu.RUnlock()
+	if nc.ujwt == _EMPTY_ {
+		return nil, ErrNoUserJWT
+	}
+	if nc.uclaims == nil {
+		return decodeUserJWT(nc.ujwt)
+	}
+	uc := *nc.uclaims
+	return &uc, nil
+}
+
+// Reauthenticate authenticates again with the credentials returned by
+// the UserJWT callback, the credentials file or the token handler. A new
+// connection is established and subscriptions are replayed on it before
+// the current one is closed, so that no message is lost, although some
+// may be received twice. ReconnectedCB, if set, is invoked once done.
+// It returns once the connection moved over, or with the error of the
+// last server tried if none accepted the new credentials, in which case
+// the current connection is kept. If the connection is reconnecting, the
+// new credentials are used by the reconnect process.
+func (nc *Conn) Reauthenticate() error {
+	nc.mu.Lock()
+	// A migration in progress may have authenticated with the previous
+	// credentials already, wait for it to be over.
+	for nc.mig != nil {
+		over := nc.mig.over
+		nc.mu.Unlock()
+		<-over
+		nc.mu.Lock()
+	}
+	switch {
+	case nc.isClosed():
+		nc.mu.Unlock()
+		return ErrConnectionClosed
+	case nc.isDraining():
+		nc.mu.Unlock()
+		return ErrConnectionDraining
+	case nc.status != CONNECTED:
+		nc.mu.Unlock()
+		return nil
+	}
+	nc.mig = newMigration()
+	nc.mu.Unlock()
+	return nc.migrate(true)
+}
+
+// setUserJWT records the user JWT the connection authenticated with and
+// schedules the expiry notification. Lock is assumed held.
+func (nc *Conn) setUserJWT(token string) {
+	if token == nc.ujwt {
+		return
+	}
+	nc.ujwt = token
+	nc.uclaims, _ = decodeUserJWT(token)
+
+	if nc.credtmr != nil {
+		nc.credtmr.Stop()
+		nc.credtmr = nil
+	}
+	uc := nc.uclaims
+	if uc == nil || uc.Expires.IsZero() || nc.isClosed() {
+		return
+	}
+	if nc.Opts.CredentialsExpiringCB == nil && nc.Opts.CredentialsRefreshCB == nil {
+		return
+	}
+	warning := nc.Opts.CredentialsExpiryWarning
+	if warning <= 0 {
+		warning = DefaultCredentialsExpiryWarning
+	}
+	d := time.Until(uc.Expires.Add(-warning))
+	if d < 0 {
+		d = 0
+	}
+	nc.credtmr = time.AfterFunc(d, func() { nc.credentialsExpiring(uc) })
+}
+
+// credentialsExpiring notifies that the user JWT is about to expire and
+// renews the credentials if a refresh callback is set.
+func (nc *Conn) credentialsExpiring(uc *UserClaims) {
+	nc.mu.Lock()
+	// Ignore if we authenticated since with other credentials.
+	if nc.isClosed() || nc.uclaims != uc {
+		nc.mu.Unlock()
+		return
+	}
+	if cb := nc.Opts.CredentialsExpiringCB; cb != nil {
+		expires := uc.Expires
+		nc.ach.push(func() { cb(nc, expires) })
+	}
+	refresh := nc.Opts.CredentialsRefreshCB
+	nc.mu.Unlock()
+
+	if refresh == nil {
+		return
+	}
+	err := refresh(nc)
+	if err == nil {
+		err = nc.Reauthenticate()
+	}
+	if err != nil {
+		nc.credentialsError(err)
+	}
+}
+
+// credentialsError reports the failure to renew the credentials to the
+// async error handler.
+func (nc *Conn) credentialsError(err error) {
+	nc.mu.Lock()
+	if !nc.isClosed() && nc.Opts.AsyncErrorCB != nil {
+		nc.ach.push(func() { nc.Opts.AsyncErrorCB(nc, nil, err) })
+	}
+	nc.mu.Unlock()
+}
+
+// startCredentialsWatcher starts checking the credentials file, if asked to.
+func (nc *Conn) startCredentialsWatcher() {
+	if nc.Opts.CredentialsWatchInterval <= 0 || nc.Opts.credsFile == _EMPTY_ {
+		return
+	}
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	if nc.isClosed() {
+		return
+	}
+	nc.credwtmr = time.AfterFunc(nc.Opts.CredentialsWatchInterval, nc.checkCredentialsFile)
+}
+
+// checkCredentialsFile re-authenticates if the user JWT in the
+// credentials file is not the one we authenticated with.
+func (nc *Conn) checkCredentialsFile() {
+	token, err := userFromFile(nc.Opts.credsFile)
+
+	nc.mu.Lock()
+	// Stopped on close.
+	if nc.credwtmr == nil {
+		nc.mu.Unlock()
+		return
+	}
+	// A failed attempt leaves the old JWT in place, so it is retried.
+	changed := err == nil && nc.ujwt != _EMPTY_ && token != nc.ujwt
+	nc.credwtmr.Reset(nc.Opts.CredentialsWatchInterval)
+	nc.mu.Unlock()
+
+	if !changed {
+		return
+	}
+	if err := nc.Reauthenticate(); err != nil {
+		nc.credentialsError(err)
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright 2021 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ServerProvider supplies the URLs of the servers to connect to. It is
+// consulted when connecting and on every pass of the reconnect loop,
+// so that the server pool follows DNS or a service registry. Servers
+// discovered through the cluster are still added to the pool.
+type ServerProvider interface {
+	Servers() ([]string, error)
+}
+
+// Lookups are variables so that tests can replace them.
+var (
+	lookupSRV = net.LookupSRV
+	lookupTXT = net.LookupTXT
+)
+
+// SRVServerProvider is a ServerProvider looking up servers in DNS SRV
+// records, e.g. _nats._tcp.nats.default.svc.cluster.local.
+type SRVServerProvider struct {
+	// Service and Proto are the service and protocol parts of the
+	// record. When both are empty, Name is looked up directly.
+	Service string
+	Proto   string
+	Name    string
+
+	// Scheme is used for the returned URLs, "nats" if empty.
+	Scheme string
+}
+
+// Servers implements ServerProvider.
+func (p *SRVServerProvider) Servers() ([]string, error) {
+	_, addrs, err := lookupSRV(p.Service, p.Proto, p.Name)
+	if err != nil {
+		return nil, err
+	}
+	scheme := p.Scheme
+	if scheme == _EMPTY_ {
+		scheme = "nats"
+	}
+	servers := make([]string, 0, len(addrs))
+	for _, a := range addrs {
+		host := strings.TrimSuffix(a.Target, ".")
+		servers = append(servers, fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(host, strconv.Itoa(int(a.Port)))))
+	}
+	return servers, nil
+}
+
+// TXTServerProvider is a ServerProvider reading server URLs from the
+// DNS TXT records of Name. A record can hold several URLs separated by
+// commas or spaces.
+type TXTServerProvider struct {
+	Name string
+}
+
+// Servers implements ServerProvider.
+func (p *TXTServerProvider) Servers() ([]string, error) {
+	records, err := lookupTXT(p.Name)
+	if err != nil {
+		return nil, err
+	}
+	var servers []string
+	for _, r := range records {
+		servers = append(servers, strings.FieldsFunc(r, func(c rune) bool {
+			return c == ',' || c == ' '
+		})...)
+	}
+	return servers, nil
+}
+
+// SetServerProvider is an Option to set the ServerProvider used to find
+// the servers to connect to, in addition to the configured URLs.
+func SetServerProvider(provider ServerProvider) Option {
+	return func(o *Options) error {
+		o.ServerProvider = provider
+		return nil
+	}
+}
+
+// SetServers replaces the servers the connection may reconnect to.
+// Servers discovered through the cluster are kept. The server we are
+// currently connected to is only dropped on the next reconnect.
+func (nc *Conn) SetServers(servers []string) error {
+	if len(servers) == 0 {
+		return ErrNoServers
+	}
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	if nc.isClosed() {
+		return ErrConnectionClosed
+	}
+	if nc.Opts.InProcessServer != nil {
+		return ErrInvalidConnection
+	}
+	return nc.replaceServers(servers, false)
+}
+
+// providerServers asks the provider, if any, for the current servers.
+// Must not be called with the lock held, as the lookup may block.
+func (nc *Conn) providerServers() []string {
+	p := nc.Opts.ServerProvider
+	if p == nil || nc.Opts.InProcessServer != nil {
+		return nil
+	}
+	servers, err := p.Servers()
+	if err != nil || len(servers) == 0 {
+		// Keep what we have, the lookup will be retried.
+		return nil
+	}
+	return servers
+}
+
+// replaceServers replaces the servers given by the provider, or all
+// configured servers if fromProvider is false, with the ones in urls.
+// Lock is assumed held.
+func (nc *Conn) replaceServers(urls []string, fromProvider bool) error {
+	parsed := make([]*url.URL, 0, len(urls))
+	keep := make(map[string]struct{}, len(urls))
+	for _, s := range urls {
+		u, err := nc.parseServerURL(s)
+		if err != nil {
+			return err
+		}
+		parsed = append(parsed, u)
+		keep[poolKey(u)] = struct{}{}
+	}
+
+	replaced := func(s *srv) bool {
+		if fromProvider {
+			return s.fromProvider
+		}
+		return !s.isImplicit
+	}
+	sp := nc.srvPool
+	for i := 0; i < len(sp); i++ {
+		s := sp[i]
+		key := poolKey(s.url)
+		if _, ok := keep[key]; ok {
+			// Already in the pool. Make sure it is no longer
+			// removed when gossip stops reporting it.
+			s.removed = false
+			if s.isImplicit {
+				s.isImplicit = false
+				s.fromProvider = fromProvider
+			}
+			delete(keep, key)
+			continue
+		}
+		if !replaced(s) {
+			continue
+		}
+		if s == nc.current {
+			s.removed = true
+			continue
+		}
+		copy(sp[i:], sp[i+1:])
+		nc.srvPool = sp[:len(sp)-1]
+		sp = nc.srvPool
+		i--
+	}
+
+	var added bool
+	for _, u := range parsed {
+		key := poolKey(u)
+		if _, ok := keep[key]; !ok {
+			continue
+		}
+		delete(keep, key)
+		nc.srvPool = append(nc.srvPool, &srv{url: u, fromProvider: fromProvider})
+		nc.urls[key] = struct{}{}
+		added = true
+	}
+	if added && !nc.Opts.NoRandomize {
+		nc.shufflePool(1)
+	}
+	return nil
+}
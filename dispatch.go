@@ -0,0 +1,190 @@
+// Copyright 2021 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Maximum number of messages delivered to a subscription before the
+// dispatcher moves on to the next one with pending messages.
+const dispatchBatch = 64
+
+// dispatcher delivers the messages of asynchronous subscriptions from a
+// fixed number of Go routines, instead of one per subscription. A
+// subscription with pending messages is in the run queue at most once,
+// or being run, which keeps its messages in order.
+type dispatcher struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	closed bool
+
+	// Run queue, as a ring buffer of count subscriptions from head.
+	queue []*Subscription
+	head  int
+	count int
+}
+
+// SubDispatchers is an Option to deliver the messages of all asynchronous
+// subscriptions from n Go routines shared by the connection, rather than
+// from one Go routine per subscription. Messages of a subscription are
+// still delivered in order, one at a time, but a handler that blocks
+// holds one of the n Go routines. Zero, the default, disables the pool.
+func SubDispatchers(n int) Option {
+	return func(o *Options) error {
+		if n < 0 {
+			return ErrBadDispatchers
+		}
+		o.SubDispatchers = n
+		return nil
+	}
+}
+
+func newDispatcher(nc *Conn, n int) *dispatcher {
+	d := &dispatcher{queue: make([]*Subscription, 16)}
+	d.cond = sync.NewCond(&d.mu)
+	for i := 0; i < n; i++ {
+		go d.run(nc)
+	}
+	return d
+}
+
+// schedule adds s to the run queue, unless already there or being run.
+// Subscription lock is assumed held.
+func (d *dispatcher) schedule(s *Subscription) {
+	if s.queued {
+		return
+	}
+	d.mu.Lock()
+	if !d.closed {
+		s.queued = true
+		d.push(s)
+	}
+	d.mu.Unlock()
+}
+
+// push appends s to the run queue. Lock is assumed held.
+func (d *dispatcher) push(s *Subscription) {
+	if d.count == len(d.queue) {
+		q := make([]*Subscription, 2*len(d.queue))
+		n := copy(q, d.queue[d.head:])
+		copy(q[n:], d.queue[:d.head])
+		d.queue, d.head = q, 0
+	}
+	d.queue[(d.head+d.count)%len(d.queue)] = s
+	d.count++
+	d.cond.Signal()
+}
+
+// stop makes the Go routines exit once the run queue is empty.
+func (d *dispatcher) stop() {
+	d.mu.Lock()
+	d.closed = true
+	d.cond.Broadcast()
+	d.mu.Unlock()
+}
+
+// run is the loop of a dispatcher Go routine.
+func (d *dispatcher) run(nc *Conn) {
+	d.mu.Lock()
+	for {
+		for d.count == 0 && !d.closed {
+			d.cond.Wait()
+		}
+		if d.count == 0 {
+			break
+		}
+		s := d.queue[d.head]
+		d.queue[d.head] = nil
+		d.head = (d.head + 1) % len(d.queue)
+		d.count--
+		d.mu.Unlock()
+
+		more := nc.dispatchMsgs(s)
+
+		d.mu.Lock()
+		if more {
+			d.push(s)
+		}
+	}
+	d.mu.Unlock()
+}
+
+// dispatchMsgs delivers up to dispatchBatch pending messages of s, the way
+// waitForMsgs does, and returns true if s must be queued again.
+func (nc *Conn) dispatchMsgs(s *Subscription) bool {
+	for i := 0; i < dispatchBatch; i++ {
+		s.mu.Lock()
+		if s.closed {
+			s.queued = false
+			s.mu.Unlock()
+			releaseBarriers(s)
+			return false
+		}
+		m := s.pHead
+		if m == nil || s.paused {
+			s.queued = false
+			s.mu.Unlock()
+			return false
+		}
+		s.pHead = m.next
+		if s.pHead == nil {
+			s.pTail = nil
+		}
+		if m.barrier != nil {
+			s.mu.Unlock()
+			if atomic.AddInt64(&m.barrier.refs, -1) == 0 {
+				m.barrier.f()
+			}
+			continue
+		}
+		msgLen := len(m.Data)
+		mcb := s.mcb
+		max := s.max
+		s.delivered++
+		delivered := s.delivered
+		s.mu.Unlock()
+
+		// Deliver the message.
+		if max == 0 || delivered <= max {
+			nc.callHandler(s, mcb, m)
+		}
+
+		// Accounting is done after the callback for drain state to trip
+		// once it has returned.
+		s.mu.Lock()
+		s.pendingDone(msgLen)
+		s.mu.Unlock()
+
+		// If we have hit the max for delivered msgs, remove sub, the next
+		// round releases the barriers left.
+		if max > 0 && delivered >= max {
+			nc.mu.Lock()
+			nc.removeSub(s)
+			nc.mu.Unlock()
+		}
+	}
+	return true
+}
+
+// signal wakes up the delivery of the messages of an asynchronous
+// subscription. Subscription lock is assumed held.
+func (s *Subscription) signal() {
+	if s.disp != nil {
+		s.disp.schedule(s)
+	} else if s.pCond != nil {
+		s.pCond.Signal()
+	}
+}
@@ -0,0 +1,269 @@
+// Copyright 2021 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package gateway streams NATS messages to HTTP clients, either as
+// Server-Sent Events or as WebSocket frames.
+//
+// Each HTTP client gets its own subscriptions, built from the subject
+// filters in the query string, e.g. /events?subject=orders.>&subject=alerts.*.
+// Payloads are forwarded as text and are expected to be valid UTF-8.
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// DefaultParam is the query parameter holding the subject filters.
+const DefaultParam = "subject"
+
+// ErrNoSubjects is reported to clients that did not ask for any subject.
+var ErrNoSubjects = errors.New("gateway: no subjects requested")
+
+// AuthorizeFunc decides whether the client behind r may receive messages
+// on the given subjects. A non-nil error rejects the request with a 403.
+type AuthorizeFunc func(r *http.Request, subjects []string) error
+
+// DefaultMaxQueued is the number of messages queued for a client when
+// Handler.MaxQueued is zero.
+const DefaultMaxQueued = 256
+
+// Handler is an http.Handler that subscribes on behalf of each client and
+// forwards matching messages. Requests carrying a WebSocket upgrade are
+// answered with a WebSocket, all others with an event stream.
+type Handler struct {
+	// Conn is the connection used to create the subscriptions.
+	Conn *nats.Conn
+
+	// Param is the query parameter holding the subject filters,
+	// DefaultParam if empty.
+	Param string
+
+	// Authorize, if set, is called before subscribing.
+	Authorize AuthorizeFunc
+
+	// PendingMsgsLimit and PendingBytesLimit are applied to every
+	// subscription of a client. Messages beyond those limits are
+	// dropped for that client only. Zero keeps the library defaults.
+	PendingMsgsLimit  int
+	PendingBytesLimit int
+
+	// MaxQueued is the number of messages queued for a client, waiting
+	// to be written to it, DefaultMaxQueued if zero. Messages are written
+	// to each client from a Go routine of its own, so that a slow client
+	// does not hold up the delivery of messages to others. Messages that
+	// arrive while its queue is full are dropped for that client only,
+	// unless DisconnectSlow is set.
+	MaxQueued int
+
+	// DisconnectSlow disconnects a client whose queue is full instead of
+	// dropping messages. Its subscriptions are removed right away.
+	DisconnectSlow bool
+
+	// KeepAlive, if positive, is the interval at which an event stream
+	// sends a comment line so that idle clients and proxies keep the
+	// connection open.
+	KeepAlive time.Duration
+}
+
+// client is a single HTTP client. Its subscriptions queue messages with
+// send, which never blocks, and the Go routine serving the client writes
+// them.
+type client struct {
+	queue      chan *nats.Msg
+	done       chan struct{}
+	disconnect bool
+
+	mu      sync.Mutex
+	closed  bool
+	subs    []*nats.Subscription
+	onClose func()
+}
+
+func (h *Handler) newClient() *client {
+	max := h.MaxQueued
+	if max <= 0 {
+		max = DefaultMaxQueued
+	}
+	return &client{
+		queue:      make(chan *nats.Msg, max),
+		done:       make(chan struct{}),
+		disconnect: h.DisconnectSlow,
+	}
+}
+
+// send queues m to be written to the client, dropping it, or closing
+// the client if asked to, when the queue is full.
+func (c *client) send(m *nats.Msg) {
+	select {
+	case <-c.done:
+		return
+	default:
+	}
+	select {
+	case c.queue <- m:
+	default:
+		if c.disconnect {
+			c.close()
+		}
+	}
+}
+
+// track records a subscription of c, removed once c is closed.
+func (c *client) track(sub *nats.Subscription) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		sub.Unsubscribe()
+		return
+	}
+	c.subs = append(c.subs, sub)
+	c.mu.Unlock()
+}
+
+// close removes the subscriptions of c and wakes up its Go routine.
+func (c *client) close() {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.closed = true
+	close(c.done)
+	subs, onClose := c.subs, c.onClose
+	c.subs = nil
+	c.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.Unsubscribe()
+	}
+	if onClose != nil {
+		onClose()
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	param := h.Param
+	if param == "" {
+		param = DefaultParam
+	}
+	subjects := r.URL.Query()[param]
+	if len(subjects) == 0 {
+		http.Error(w, ErrNoSubjects.Error(), http.StatusBadRequest)
+		return
+	}
+	if h.Authorize != nil {
+		if err := h.Authorize(r, subjects); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+	}
+	if isWebSocketUpgrade(r) {
+		h.serveWebSocket(w, r, subjects)
+	} else {
+		h.serveEvents(w, r, subjects)
+	}
+}
+
+// subscribe creates the subscriptions of c. Messages are queued until
+// the client is served. They are all removed if one of them fails.
+func (h *Handler) subscribe(c *client, subjects []string) error {
+	for _, subj := range subjects {
+		sub, err := h.Conn.Subscribe(subj, c.send)
+		if err == nil && (h.PendingMsgsLimit != 0 || h.PendingBytesLimit != 0) {
+			msgs, bytes := h.PendingMsgsLimit, h.PendingBytesLimit
+			if msgs == 0 {
+				msgs = nats.DefaultSubPendingMsgsLimit
+			}
+			if bytes == 0 {
+				bytes = nats.DefaultSubPendingBytesLimit
+			}
+			err = sub.SetPendingLimits(msgs, bytes)
+		}
+		if sub != nil {
+			c.track(sub)
+		}
+		if err != nil {
+			c.close()
+			return fmt.Errorf("gateway: subject %q: %v", subj, err)
+		}
+	}
+	return nil
+}
+
+func (h *Handler) serveEvents(w http.ResponseWriter, r *http.Request, subjects []string) {
+	f, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "gateway: streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	c := h.newClient()
+	defer c.close()
+	if err := h.subscribe(c, subjects); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	f.Flush()
+
+	var tick <-chan time.Time
+	if h.KeepAlive > 0 {
+		t := time.NewTicker(h.KeepAlive)
+		defer t.Stop()
+		tick = t.C
+	}
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-c.done:
+			return
+		case m := <-c.queue:
+			if err := writeEvent(w, m); err != nil {
+				return
+			}
+			f.Flush()
+		case <-tick:
+			if _, err := w.Write([]byte(":\n\n")); err != nil {
+				return
+			}
+			f.Flush()
+		}
+	}
+}
+
+// writeEvent writes m as an event named after its subject. Every line
+// of the payload becomes its own data field.
+func writeEvent(w http.ResponseWriter, m *nats.Msg) error {
+	var sb strings.Builder
+	sb.WriteString("event: ")
+	sb.WriteString(m.Subject)
+	sb.WriteString("\n")
+	for _, line := range strings.Split(string(m.Data), "\n") {
+		sb.WriteString("data: ")
+		sb.WriteString(strings.TrimSuffix(line, "\r"))
+		sb.WriteString("\n")
+	}
+	sb.WriteString("\n")
+	_, err := w.Write([]byte(sb.String()))
+	return err
+}
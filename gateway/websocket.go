@@ -0,0 +1,228 @@
+// Copyright 2021 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gateway
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Only what is needed to push text frames and notice the client leaving
+// is implemented here, see RFC 6455.
+const (
+	wsGUID       = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	wsTextFrame  = 0x1
+	wsCloseFrame = 0x8
+	wsPingFrame  = 0x9
+	wsPongFrame  = 0xA
+	wsFinalBit   = 0x80
+	wsMaskBit    = 0x80
+
+	// Clients are not expected to send data, so anything large is
+	// treated as a protocol error.
+	wsMaxClientPayload = 4096
+	wsWriteTimeout     = 10 * time.Second
+)
+
+var errWSProtocol = errors.New("gateway: websocket protocol error")
+
+// wsMessage is the content of every text frame sent to a client.
+type wsMessage struct {
+	Subject string `json:"subject"`
+	Reply   string `json:"reply,omitempty"`
+	Data    string `json:"data"`
+}
+
+func isWebSocketUpgrade(r *http.Request) bool {
+	return headerContains(r.Header, "Connection", "upgrade") &&
+		headerContains(r.Header, "Upgrade", "websocket")
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h[http.CanonicalHeaderKey(name)] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func wsAcceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func (h *Handler) serveWebSocket(w http.ResponseWriter, r *http.Request, subjects []string) {
+	key := r.Header.Get("Sec-Websocket-Key")
+	if r.Method != http.MethodGet || key == "" || r.Header.Get("Sec-Websocket-Version") != "13" {
+		http.Error(w, "gateway: invalid websocket handshake", http.StatusBadRequest)
+		return
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "gateway: websocket not supported", http.StatusInternalServerError)
+		return
+	}
+
+	c := h.newClient()
+	defer c.close()
+	if err := h.subscribe(c, subjects); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	// Closing the connection of a client disconnected for being slow
+	// aborts a pending write.
+	c.mu.Lock()
+	c.onClose = func() { conn.Close() }
+	c.mu.Unlock()
+
+	brw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + wsAcceptKey(key) + "\r\n\r\n")
+	if err := brw.Flush(); err != nil {
+		return
+	}
+
+	// The read loop only answers pings and waits for the client to go
+	// away. Its writes are serialized with the ones of messages.
+	var wmu sync.Mutex
+	go func() {
+		defer c.close()
+		for {
+			op, payload, err := wsReadFrame(brw.Reader)
+			if err != nil {
+				return
+			}
+			switch op {
+			case wsCloseFrame:
+				wmu.Lock()
+				wsWriteFrame(conn, brw.Writer, wsCloseFrame, payload)
+				wmu.Unlock()
+				return
+			case wsPingFrame:
+				wmu.Lock()
+				err = wsWriteFrame(conn, brw.Writer, wsPongFrame, payload)
+				wmu.Unlock()
+				if err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case m := <-c.queue:
+			b, err := json.Marshal(&wsMessage{Subject: m.Subject, Reply: m.Reply, Data: string(m.Data)})
+			if err != nil {
+				continue
+			}
+			wmu.Lock()
+			err = wsWriteFrame(conn, brw.Writer, wsTextFrame, b)
+			wmu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+func wsWriteFrame(conn net.Conn, bw *bufio.Writer, op byte, payload []byte) error {
+	var hdr [10]byte
+	hdr[0] = wsFinalBit | op
+	n := len(payload)
+	hl := 2
+	switch {
+	case n <= 125:
+		hdr[1] = byte(n)
+	case n <= 0xFFFF:
+		hdr[1] = 126
+		binary.BigEndian.PutUint16(hdr[2:], uint16(n))
+		hl = 4
+	default:
+		hdr[1] = 127
+		binary.BigEndian.PutUint64(hdr[2:], uint64(n))
+		hl = 10
+	}
+	conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	bw.Write(hdr[:hl])
+	bw.Write(payload)
+	return bw.Flush()
+}
+
+// wsReadFrame reads a single frame sent by the client and returns its
+// opcode and unmasked payload.
+func wsReadFrame(br *bufio.Reader) (byte, []byte, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	op := hdr[0] & 0x0F
+	// Frames from clients must be masked.
+	if hdr[1]&wsMaskBit == 0 {
+		return 0, nil, errWSProtocol
+	}
+	n := uint64(hdr[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(br, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(br, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > wsMaxClientPayload {
+		return 0, nil, errWSProtocol
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(br, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(br, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return op, payload, nil
+}
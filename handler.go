@@ -0,0 +1,168 @@
+// Copyright 2021 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync/atomic"
+)
+
+// MsgHandlerWithError is a message handler that can fail. See HandleErrors.
+type MsgHandlerWithError func(msg *Msg) error
+
+// PanicError is reported to the async error handler for a panic of the
+// handler of an asynchronous subscription, with RecoverPanics or
+// HandlePanics.
+type PanicError struct {
+	// Msg is the message being handled. For a pooled subscription,
+	// only its Subject, Reply and Sub are set, since the message itself
+	// may have been released and reused.
+	Msg *Msg
+	// Value is the value the handler panicked with.
+	Value interface{}
+	// Stack is the stack trace of the Go routine that panicked.
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("nats: panic in handler of %q: %v", e.Msg.Subject, e.Value)
+}
+
+// HandlerError is reported to the async error handler for an error
+// returned by a handler given to HandleErrors.
+type HandlerError struct {
+	// Msg is the message that failed to be handled. For a pooled
+	// subscription, only its Subject, Reply and Sub are set, since the
+	// message itself may have been released and reused.
+	Msg *Msg
+	// Err is the error returned by the handler.
+	Err error
+}
+
+func (e *HandlerError) Error() string {
+	return fmt.Sprintf("nats: handler of %q failed: %v", e.Msg.Subject, e.Err)
+}
+
+// Unwrap returns the error returned by the handler.
+func (e *HandlerError) Unwrap() error {
+	return e.Err
+}
+
+// RecoverPanics is an Option to recover from panics of the handlers of
+// asynchronous subscriptions, which would otherwise crash the process.
+// The panic is reported to the async error handler as a *PanicError,
+// with the subscription, and the subscription keeps going with the next
+// message. A JetStream message that is not acknowledged yet is Nak'ed.
+// See HandlePanics to do so for some subscriptions only.
+func RecoverPanics() Option {
+	return func(o *Options) error {
+		o.RecoverPanics = true
+		return nil
+	}
+}
+
+// HandlePanics returns a MsgHandler calling h and recovering from its
+// panics, as done for all subscriptions with RecoverPanics.
+func HandlePanics(h MsgHandler) MsgHandler {
+	return func(m *Msg) {
+		callRecovering(newMsgRef(m.Sub, m), h, m)
+	}
+}
+
+// HandleErrors returns a MsgHandler calling h, for which the errors it
+// returns are reported to the async error handler as a *HandlerError,
+// with the subscription. A JetStream message that is not acknowledged
+// yet is Nak'ed, so that it is redelivered, or Term'ed if the error is,
+// or wraps, ErrTerminate.
+func HandleErrors(h MsgHandlerWithError) MsgHandler {
+	return func(m *Msg) {
+		r := newMsgRef(m.Sub, m)
+		if err := h(m); err != nil {
+			r.failed(&HandlerError{Msg: r.msg(), Err: err})
+		}
+	}
+}
+
+// callHandler invokes the handler of an asynchronous subscription with
+// m, once its headers are decoded, recovering from a panic with
+// RecoverPanics.
+func (nc *Conn) callHandler(s *Subscription, mcb MsgHandler, m *Msg) {
+	m.decodeHeader()
+	if nc.Opts.RecoverPanics {
+		callRecovering(newMsgRef(s, m), mcb, m)
+		return
+	}
+	mcb(m)
+}
+
+// callRecovering invokes h with m, reporting a panic of h.
+func callRecovering(r msgRef, h MsgHandler, m *Msg) {
+	defer func() {
+		if v := recover(); v != nil {
+			r.failed(&PanicError{Msg: r.msg(), Value: v, Stack: debug.Stack()})
+		}
+	}()
+	h(m)
+}
+
+// msgRef keeps what is needed to report the failure of the handler of a
+// message. A pooled message may be released, and reused for another
+// delivery, by the time the handler returns, so it is not used then.
+type msgRef struct {
+	m     *Msg
+	subj  string
+	reply string
+	sub   *Subscription
+}
+
+func newMsgRef(sub *Subscription, m *Msg) msgRef {
+	return msgRef{m: m, subj: m.Subject, reply: m.Reply, sub: sub}
+}
+
+// msg returns the message, or a copy of its subject and reply if pooled.
+func (r msgRef) msg() *Msg {
+	if r.m.pooled {
+		return &Msg{Subject: r.subj, Reply: r.reply, Sub: r.sub}
+	}
+	return r.m
+}
+
+// failed reports err, the failure of the handler of the message, and
+// nak's or terminates a JetStream message not acknowledged yet.
+func (r msgRef) failed(err error) {
+	sub := r.sub
+	if sub == nil {
+		return
+	}
+	sub.mu.Lock()
+	nc, isJS := sub.conn, sub.jsi != nil
+	sub.mu.Unlock()
+
+	// Pooled subscriptions are not JetStream ones.
+	if isJS && !r.m.pooled && r.reply != _EMPTY_ && atomic.LoadUint32(&r.m.ackd) == 0 {
+		if errors.Is(err, ErrTerminate) {
+			r.m.Term()
+		} else {
+			r.m.Nak()
+		}
+	}
+
+	nc.mu.Lock()
+	if nc.Opts.AsyncErrorCB != nil {
+		nc.ach.push(func() { nc.Opts.AsyncErrorCB(nc, sub, err) })
+	}
+	nc.mu.Unlock()
+}
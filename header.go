@@ -0,0 +1,342 @@
+// Copyright 2021 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Header represents the headers of a message. Unlike http.Header, keys
+// are case-sensitive: they are neither canonicalized when set nor when
+// looked up, and are sent as is.
+//
+// See FromHTTPHeader to use an http.Header as a Header. The other way
+// around, a Header converts to an http.Header, as in http.Header(m.Header),
+// in which case the lookups by the http.Header methods only find the keys
+// that are in the canonical format.
+//
+// The headers of a received message are decoded once it is handed over to
+// the application, by its handler, NextMsg or channel.
+type Header map[string][]string
+
+// FromHTTPHeader returns h as a Header, for code that used to set the
+// headers of a message to an http.Header, which now reads
+// msg.Header = nats.FromHTTPHeader(h). The map is shared, not copied. Keys are sent as they are in h, so in the
+// canonical format if set with the http.Header methods.
+func FromHTTPHeader(h http.Header) Header {
+	return Header(h)
+}
+
+// Add adds the key, value pair to the header. It appends to any existing
+// values associated with key.
+func (h Header) Add(key, value string) {
+	h[key] = append(h[key], value)
+}
+
+// Set sets the header entries associated with key to the single element
+// value. It replaces any existing values associated with key.
+func (h Header) Set(key, value string) {
+	h[key] = []string{value}
+}
+
+// Get gets the first value associated with the given key. If there are
+// no values associated with the key, Get returns "".
+func (h Header) Get(key string) string {
+	if v := h[key]; len(v) > 0 {
+		return v[0]
+	}
+	return _EMPTY_
+}
+
+// Values returns all values associated with the given key. The returned
+// slice is not a copy.
+func (h Header) Values(key string) []string {
+	return h[key]
+}
+
+// Del deletes the values associated with key.
+func (h Header) Del(key string) {
+	delete(h, key)
+}
+
+// Status returns the status code inlined by the server in the headers of
+// the message, such as 503 when there were no responders to a request, or
+// zero if there is none.
+func (m *Msg) Status() int {
+	s := m.headerGet(statusHdr)
+	if s == _EMPTY_ {
+		return 0
+	}
+	code, _ := strconv.Atoi(s)
+	return code
+}
+
+// Description returns the description that came with the status code
+// inlined by the server in the headers of the message, if any.
+func (m *Msg) Description() string {
+	return m.headerGet(descrHdr)
+}
+
+// decodeHeader decodes the header block received with m into its Header,
+// once the message is handed over to the application.
+func (m *Msg) decodeHeader() {
+	if m.hdr == nil {
+		return
+	}
+	m.Header, _ = decodeHeadersMsg(m.hdr)
+	m.hdr = nil
+}
+
+// headerGet returns the first value of key in the headers of m, looking
+// it up in the header block received if not decoded yet.
+func (m *Msg) headerGet(key string) string {
+	if m.hdr == nil {
+		return m.Header.Get(key)
+	}
+	return string(headerValue(m.hdr, key))
+}
+
+// headerIs returns true if the first value of key in the headers of m is
+// value, without allocating if they are not decoded yet.
+func (m *Msg) headerIs(key, value string) bool {
+	if m.hdr == nil {
+		return m.Header.Get(key) == value
+	}
+	return string(headerValue(m.hdr, key)) == value
+}
+
+// headerLen returns the length of the header block that h encodes to.
+func (h Header) headerLen() int {
+	n := len(hdrLine) + len(crlf)
+	for k, vs := range h {
+		for _, v := range vs {
+			n += len(k) + len(": ") + len(v) + len(crlf)
+		}
+	}
+	return n
+}
+
+// appendHeader appends the header block that h encodes to, starting with
+// the NATS/1.0 line, to b. Line breaks in values are replaced by spaces.
+func (h Header) appendHeader(b []byte) []byte {
+	b = append(b, hdrLine...)
+	for k, vs := range h {
+		for _, v := range vs {
+			b = append(b, k...)
+			b = append(b, ':', ' ')
+			start := len(b)
+			b = append(b, strings.TrimSpace(v)...)
+			for i := start; i < len(b); i++ {
+				if b[i] == '\r' || b[i] == '\n' {
+					b[i] = ' '
+				}
+			}
+			b = append(b, crlf...)
+		}
+	}
+	return append(b, crlf...)
+}
+
+// Kinds of the lines of a header block passed by walkHeader.
+const (
+	hdrStatus = iota // inlined status, the key is empty
+	hdrDescr         // description following the status, the key is empty
+	hdrField         // key and value of a header line
+	hdrFolded        // continues the value of the previous header line
+)
+
+// walkHeader checks the header block of a HMSG, without allocating. If fn
+// is not nil, it is called with the kind and the offsets in data of the
+// key and value of each line, in order, the values being trimmed. It
+// returns ErrBadHeaderMsg if the block is malformed, even if fn stopped
+// the walk by returning false.
+func walkHeader(data []byte, fn func(kind, k0, k1, v0, v1 int) bool) error {
+	// The block must end with an empty line.
+	if len(data) < len(hdrLine)+len(crlf) || string(data[len(data)-2*len(crlf):]) != crlf+crlf {
+		return ErrBadHeaderMsg
+	}
+	if string(data[:hdrPreEnd]) != hdrLine[:hdrPreEnd] {
+		return ErrBadHeaderMsg
+	}
+	if fn == nil {
+		fn = func(int, int, int, int, int) bool { return true }
+	}
+
+	// Status line.
+	end := indexCRLF(data, hdrPreEnd)
+	if v0, v1 := trimSpace(data, hdrPreEnd, end); v0 < v1 {
+		if v1-v0 < statusLen {
+			return ErrBadHeaderMsg
+		}
+		if !fn(hdrStatus, v0, v0, v0, v0+statusLen) {
+			fn = nil
+		}
+		if d0, d1 := trimSpace(data, v0+statusLen, v1); d0 < d1 && fn != nil && !fn(hdrDescr, d0, d0, d0, d1) {
+			fn = nil
+		}
+	}
+
+	inField := false
+	for pos := end + len(crlf); ; {
+		end = indexCRLF(data, pos)
+		if end < 0 {
+			return ErrBadHeaderMsg
+		}
+		line := data[pos:end]
+		start := pos
+		pos = end + len(crlf)
+		if len(line) == 0 {
+			if pos != len(data) {
+				return ErrBadHeaderMsg
+			}
+			return nil
+		}
+		// Obsolete line folding, continues the previous value.
+		if line[0] == ' ' || line[0] == '\t' {
+			if !inField {
+				return ErrBadHeaderMsg
+			}
+			if v0, v1 := trimSpace(data, start, end); fn != nil && !fn(hdrFolded, start, start, v0, v1) {
+				fn = nil
+			}
+			continue
+		}
+		i := start
+		for i < end && data[i] != ':' {
+			if data[i] == ' ' || data[i] == '\t' {
+				return ErrBadHeaderMsg
+			}
+			i++
+		}
+		if i == start || i == end {
+			return ErrBadHeaderMsg
+		}
+		inField = true
+		if v0, v1 := trimSpace(data, i+1, end); fn != nil && !fn(hdrField, start, i, v0, v1) {
+			fn = nil
+		}
+	}
+}
+
+// indexCRLF returns the offset of the first CRLF in data from offset
+// start, -1 if there is none.
+func indexCRLF(data []byte, start int) int {
+	for i := start; i+1 < len(data); i++ {
+		if data[i] == '\r' && data[i+1] == '\n' {
+			return i
+		}
+	}
+	return -1
+}
+
+// trimSpace returns the offsets of data[start:end] without its leading and
+// trailing white space.
+func trimSpace(data []byte, start, end int) (int, int) {
+	for start < end && isSpace(data[start]) {
+		start++
+	}
+	for end > start && isSpace(data[end-1]) {
+		end--
+	}
+	return start, end
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\v' || c == '\f'
+}
+
+// decodeHeadersMsg decodes the header block of a HMSG, keeping the case
+// of the keys. Keys and values share one copy of data, and the values of
+// all keys one backing array. An inlined status and description are
+// reported as the Status and Description headers.
+func decodeHeadersMsg(data []byte) (Header, error) {
+	// Count lines to size the header at once.
+	lines := 0
+	for i := 0; i+1 < len(data); i++ {
+		if data[i] == '\r' && data[i+1] == '\n' {
+			lines++
+			i++
+		}
+	}
+	s := string(data)
+	h := make(Header, lines)
+	vals := make([]string, 0, lines+1)
+	var last string
+	err := walkHeader(data, func(kind, k0, k1, v0, v1 int) bool {
+		var key string
+		switch kind {
+		case hdrFolded:
+			vs := h[last]
+			vs[len(vs)-1] += " " + s[v0:v1]
+			return true
+		case hdrStatus:
+			key = statusHdr
+		case hdrDescr:
+			key = descrHdr
+		default:
+			key = s[k0:k1]
+		}
+		if vs, ok := h[key]; ok {
+			h[key] = append(vs, s[v0:v1])
+		} else {
+			vals = append(vals, s[v0:v1])
+			h[key] = vals[len(vals)-1 : len(vals) : len(vals)]
+		}
+		last = key
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// headerValue returns the first value of key in the header block data,
+// which must be valid, nil if it is not found. It is not a copy, unless
+// the value is folded on several lines.
+func headerValue(data []byte, key string) []byte {
+	var (
+		found bool
+		in    bool
+		v     []byte
+	)
+	walkHeader(data, func(kind, k0, k1, v0, v1 int) bool {
+		switch kind {
+		case hdrFolded:
+			if in {
+				v = append(append(v[:len(v):len(v)], ' '), data[v0:v1]...)
+			}
+			return true
+		case hdrStatus:
+			in = key == statusHdr
+		case hdrDescr:
+			in = key == descrHdr
+		default:
+			in = string(data[k0:k1]) == key
+		}
+		if found {
+			return false
+		}
+		if in {
+			found, v = true, data[v0:v1]
+		}
+		return true
+	})
+	if !found {
+		return nil
+	}
+	return v
+}
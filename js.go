@@ -20,7 +20,6 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"net/http"
 	"strconv"
 	"strings"
 	"sync"
@@ -35,6 +34,9 @@ const (
 	// defaultAPIPrefix is the default prefix for the JetStream API.
 	defaultAPIPrefix = "$JS.API."
 
+	// jsDomainT is the prefix for the JetStream API of a domain.
+	jsDomainT = "$JS.%s.API."
+
 	// apiAccountInfo is for obtaining general information about JetStream.
 	apiAccountInfo = "INFO"
 
@@ -158,6 +160,8 @@ type jsOpts struct {
 	aecb MsgErrHandler
 	// Maximum in flight.
 	maxap int
+	// For the JetStream context of a namespace.
+	ns *Namespace
 }
 
 const (
@@ -175,6 +179,13 @@ func (nc *Conn) JetStream(opts ...JSOpt) (JetStreamContext, error) {
 		},
 	}
 
+	if pre := nc.Opts.JetStreamAPIPrefix; pre != _EMPTY_ {
+		APIPrefix(pre).configureJSContext(js.opts)
+	} else if domain := nc.Opts.JetStreamDomain; domain != _EMPTY_ {
+		if err := Domain(domain).configureJSContext(js.opts); err != nil {
+			return nil, err
+		}
+	}
 	for _, opt := range opts {
 		if err := opt.configureJSContext(js.opts); err != nil {
 			return nil, err
@@ -226,6 +237,18 @@ func APIPrefix(pre string) JSOpt {
 	})
 }
 
+// Domain changes the prefix used for the JetStream API to the one of the
+// given JetStream domain.
+func Domain(domain string) JSOpt {
+	return jsOptFn(func(js *jsOpts) error {
+		if domain == _EMPTY_ || strings.ContainsAny(domain, ".*> \t") {
+			return ErrInvalidJSDomain
+		}
+		js.pre = fmt.Sprintf(jsDomainT, domain)
+		return nil
+	})
+}
+
 func (js *js) apiSubj(subj string) string {
 	if js.opts.pre == _EMPTY_ {
 		return subj
@@ -236,6 +259,34 @@ func (js *js) apiSubj(subj string) string {
 	return b.String()
 }
 
+// nsPrefix returns the prefix of the namespace of the context, if any.
+func (js *js) nsPrefix() string {
+	if js.opts.ns == nil {
+		return _EMPTY_
+	}
+	return js.opts.ns.prefix
+}
+
+// newInbox returns a new inbox, in the namespace of the context if any.
+func (js *js) newInbox() string {
+	return js.nsPrefix() + js.nc.newInbox()
+}
+
+// apiRequest sends a request to the JetStream API, with an inbox in the
+// namespace of the context if any.
+func (js *js) apiRequest(subj string, data []byte, wait time.Duration) (*Msg, error) {
+	return js.nc.nsRequest(nil, js.nsPrefix(), subj, nil, data, wait)
+}
+
+// apiRequestWithContext sends a request to the JetStream API, with an inbox
+// in the namespace of the context if any.
+func (js *js) apiRequestWithContext(ctx context.Context, subj string, data []byte) (*Msg, error) {
+	if ctx == nil {
+		return nil, ErrInvalidContext
+	}
+	return js.nc.nsRequest(ctx, js.nsPrefix(), subj, nil, data, 0)
+}
+
 // PubOpt configures options for publishing JetStream messages.
 type PubOpt interface {
 	configurePublish(opts *pubOpts) error
@@ -255,6 +306,8 @@ type pubOpts struct {
 	lid string // Expected last msgId
 	str string // Expected stream name
 	seq uint64 // Expected last sequence
+
+	nodup bool // Fail on duplicate
 }
 
 // pubAckResponse is the ack response from the JetStream API when publishing a message.
@@ -283,7 +336,7 @@ func (js *js) PublishMsg(m *Msg, opts ...PubOpt) (*PubAck, error) {
 	var o pubOpts
 	if len(opts) > 0 {
 		if m.Header == nil {
-			m.Header = http.Header{}
+			m.Header = Header{}
 		}
 		for _, opt := range opts {
 			if err := opt.configurePublish(&o); err != nil {
@@ -315,7 +368,13 @@ func (js *js) PublishMsg(m *Msg, opts ...PubOpt) (*PubAck, error) {
 	var resp *Msg
 	var err error
 
-	if o.ttl > 0 {
+	if ns := js.opts.ns; ns != nil {
+		if o.ttl > 0 {
+			resp, err = ns.RequestMsg(m, time.Duration(o.ttl))
+		} else {
+			resp, err = ns.RequestMsgWithContext(o.ctx, m)
+		}
+	} else if o.ttl > 0 {
 		resp, err = js.nc.RequestMsg(m, time.Duration(o.ttl))
 	} else {
 		resp, err = js.nc.RequestMsgWithContext(o.ctx, m)
@@ -332,11 +391,14 @@ func (js *js) PublishMsg(m *Msg, opts ...PubOpt) (*PubAck, error) {
 		return nil, ErrInvalidJSAck
 	}
 	if pa.Error != nil {
-		return nil, fmt.Errorf("nats: %s", pa.Error.Description)
+		return nil, pa.Error
 	}
 	if pa.PubAck == nil || pa.PubAck.Stream == _EMPTY_ {
 		return nil, ErrInvalidJSAck
 	}
+	if o.nodup && pa.Duplicate {
+		return pa.PubAck, ErrDuplicateMessage
+	}
 	return pa.PubAck, nil
 }
 
@@ -365,6 +427,7 @@ type pubAckFuture struct {
 	err    error
 	errCh  chan error
 	doneCh chan *PubAck
+	nodup  bool
 }
 
 func (paf *pubAckFuture) Ok() <-chan *PubAck {
@@ -402,7 +465,6 @@ func (paf *pubAckFuture) Msg() *Msg {
 }
 
 // For quick token lookup etc.
-const aReplyPreLen = 14
 const aReplyTokensize = 6
 
 func (js *js) newAsyncReply() string {
@@ -415,8 +477,14 @@ func (js *js) newAsyncReply() string {
 		for i := 0; i < aReplyTokensize; i++ {
 			b[i] = rdigits[int(b[i]%base)]
 		}
-		js.rpre = fmt.Sprintf("%s%s.", InboxPrefix, b[:aReplyTokensize])
-		sub, err := js.nc.Subscribe(fmt.Sprintf("%s*", js.rpre), js.handleAsyncReply)
+		js.rpre = fmt.Sprintf("%s%s.", js.nc.inboxPrefix(), b[:aReplyTokensize])
+		var sub *Subscription
+		var err error
+		if ns := js.opts.ns; ns != nil {
+			sub, err = ns.Subscribe(fmt.Sprintf("%s*", js.rpre), js.handleAsyncReply)
+		} else {
+			sub, err = js.nc.Subscribe(fmt.Sprintf("%s*", js.rpre), js.handleAsyncReply)
+		}
 		if err != nil {
 			js.mu.Unlock()
 			return _EMPTY_
@@ -425,7 +493,14 @@ func (js *js) newAsyncReply() string {
 	}
 	var sb strings.Builder
 	sb.WriteString(js.rpre)
+	// The random source is only set up by the requests of the connection
+	// otherwise.
+	js.nc.mu.Lock()
+	if js.nc.respMap == nil {
+		js.nc.initNewResp()
+	}
 	rn := js.nc.respRand.Int63()
+	js.nc.mu.Unlock()
 	var b [aReplyTokensize]byte
 	for i, l := 0, rn; i < len(b); i++ {
 		b[i] = rdigits[l%base]
@@ -484,12 +559,12 @@ func (js *js) asyncStall() <-chan struct{} {
 
 // Handle an async reply from PublishAsync.
 func (js *js) handleAsyncReply(m *Msg) {
-	if len(m.Subject) <= aReplyPreLen {
+	js.mu.Lock()
+	if len(m.Subject) <= len(js.rpre) {
+		js.mu.Unlock()
 		return
 	}
-	id := m.Subject[aReplyPreLen:]
-
-	js.mu.Lock()
+	id := m.Subject[len(js.rpre):]
 	paf := js.getPAF(id)
 	if paf == nil {
 		js.mu.Unlock()
@@ -524,7 +599,7 @@ func (js *js) handleAsyncReply(m *Msg) {
 	}
 
 	// Process no responders etc.
-	if len(m.Data) == 0 && m.Header.Get(statusHdr) == noResponders {
+	if len(m.Data) == 0 && m.headerGet(statusHdr) == noResponders {
 		doErr(ErrNoResponders)
 		return
 	}
@@ -535,13 +610,18 @@ func (js *js) handleAsyncReply(m *Msg) {
 		return
 	}
 	if pa.Error != nil {
-		doErr(fmt.Errorf("nats: %s", pa.Error.Description))
+		doErr(pa.Error)
 		return
 	}
 	if pa.PubAck == nil || pa.PubAck.Stream == _EMPTY_ {
 		doErr(ErrInvalidJSAck)
 		return
 	}
+	if paf.nodup && pa.Duplicate {
+		paf.pa = pa.PubAck
+		doErr(ErrDuplicateMessage)
+		return
+	}
 
 	// So here we have received a proper puback.
 	paf.pa = pa.PubAck
@@ -584,7 +664,7 @@ func (js *js) PublishMsgAsync(m *Msg, opts ...PubOpt) (PubAckFuture, error) {
 	var o pubOpts
 	if len(opts) > 0 {
 		if m.Header == nil {
-			m.Header = http.Header{}
+			m.Header = Header{}
 		}
 		for _, opt := range opts {
 			if err := opt.configurePublish(&o); err != nil {
@@ -620,8 +700,8 @@ func (js *js) PublishMsgAsync(m *Msg, opts ...PubOpt) (PubAckFuture, error) {
 	if m.Reply == _EMPTY_ {
 		return nil, errors.New("nats: error creating async reply handler")
 	}
-	id := m.Reply[aReplyPreLen:]
-	paf := &pubAckFuture{msg: m, st: time.Now()}
+	id := m.Reply[len(m.Reply)-aReplyTokensize:]
+	paf := &pubAckFuture{msg: m, st: time.Now(), nodup: o.nodup}
 	numPending, maxPending := js.registerPAF(id, paf)
 
 	if maxPending > 0 && numPending >= maxPending {
@@ -633,7 +713,13 @@ func (js *js) PublishMsgAsync(m *Msg, opts ...PubOpt) (PubAckFuture, error) {
 		}
 	}
 
-	if err := js.nc.PublishMsg(m); err != nil {
+	var err error
+	if ns := js.opts.ns; ns != nil {
+		err = ns.PublishMsg(m)
+	} else {
+		err = js.nc.PublishMsg(m)
+	}
+	if err != nil {
 		js.clearPAF(id)
 		return nil, err
 	}
@@ -688,6 +774,16 @@ func ExpectLastMsgId(id string) PubOpt {
 	})
 }
 
+// FailOnDuplicate makes the publish fail with ErrDuplicateMessage when the
+// stream already has a message with the same MsgId. The stream reports
+// it in the PubAck, which is returned along with the error.
+func FailOnDuplicate() PubOpt {
+	return pubOptFn(func(opts *pubOpts) error {
+		opts.nodup = true
+		return nil
+	})
+}
+
 type ackOpts struct {
 	ttl time.Duration
 	ctx context.Context
@@ -825,6 +921,9 @@ type jsSub struct {
 
 	// cmeta is holds metadata from a push consumer when HBs are enabled.
 	cmeta atomic.Value
+
+	// Flow control response held while the subscription is paused.
+	fcReply string
 }
 
 // controlMetadata is metadata used to be able to detect sequence mismatch
@@ -904,6 +1003,13 @@ func (js *js) subscribe(subj, queue string, cb MsgHandler, ch chan *Msg, isSync
 		}
 	}
 
+	if ns := js.opts.ns; ns != nil {
+		var err error
+		if subj, err = ns.subject(subj, true); err != nil {
+			return nil, err
+		}
+	}
+
 	isPullMode := ch == nil && cb == nil
 	badPullAck := o.cfg.AckPolicy == AckNonePolicy || o.cfg.AckPolicy == AckAllPolicy
 	hasHeartbeats := o.cfg.Heartbeat > 0
@@ -939,7 +1045,7 @@ func (js *js) subscribe(subj, queue string, cb MsgHandler, ch chan *Msg, isSync
 	if consumer != _EMPTY_ {
 		// Only create in case there is no consumer already.
 		info, err = js.ConsumerInfo(stream, consumer)
-		if err != nil && err.Error() != "nats: consumer not found" {
+		if err != nil && !errors.Is(err, ErrConsumerNotFound) {
 			return nil, err
 		}
 	}
@@ -957,11 +1063,11 @@ func (js *js) subscribe(subj, queue string, cb MsgHandler, ch chan *Msg, isSync
 		if ccfg.DeliverSubject != _EMPTY_ {
 			deliver = ccfg.DeliverSubject
 		} else {
-			deliver = NewInbox()
+			deliver = js.newInbox()
 		}
 	} else {
 		shouldCreate = true
-		deliver = NewInbox()
+		deliver = js.newInbox()
 		if !isPullMode {
 			cfg.DeliverSubject = deliver
 		}
@@ -980,7 +1086,7 @@ func (js *js) subscribe(subj, queue string, cb MsgHandler, ch chan *Msg, isSync
 	if isPullMode {
 		sub = &Subscription{Subject: subj, conn: js.nc, typ: PullSubscription, jsi: &jsSub{js: js, pull: isPullMode}}
 	} else {
-		sub, err = js.nc.subscribe(deliver, queue, cb, ch, isSync, &jsSub{js: js, hbs: hasHeartbeats, fc: hasFC})
+		sub, err = js.nc.nsSubscribe(js.nsPrefix(), deliver, queue, cb, ch, isSync, &jsSub{js: js, hbs: hasHeartbeats, fc: hasFC})
 		if err != nil {
 			return nil, err
 		}
@@ -1018,7 +1124,7 @@ func (js *js) subscribe(subj, queue string, cb MsgHandler, ch chan *Msg, isSync
 			ccSubj = fmt.Sprintf(apiConsumerCreateT, stream)
 		}
 
-		resp, err := js.nc.Request(js.apiSubj(ccSubj), j, js.opts.wait)
+		resp, err := js.apiRequest(js.apiSubj(ccSubj), j, js.opts.wait)
 		if err != nil {
 			if err == ErrNoResponders {
 				err = ErrJetStreamNotEnabled
@@ -1035,7 +1141,7 @@ func (js *js) subscribe(subj, queue string, cb MsgHandler, ch chan *Msg, isSync
 		}
 		if info.Error != nil {
 			sub.Unsubscribe()
-			return nil, fmt.Errorf("nats: %s", info.Error.Description)
+			return nil, info.Error
 		}
 
 		// Hold onto these for later.
@@ -1054,8 +1160,11 @@ func (js *js) subscribe(subj, queue string, cb MsgHandler, ch chan *Msg, isSync
 }
 
 func (nc *Conn) processControlFlow(msg *Msg, s *Subscription, jsi *jsSub) {
-	// If it is a flow control message then have to ack.
+	// If it is a flow control message then have to ack, unless paused.
 	if msg.Reply != "" {
+		if s.holdFlowControl(msg.Reply) {
+			return
+		}
 		nc.publish(msg.Reply, _EMPTY_, nil, nil)
 	} else if jsi.hbs {
 		// Process heartbeat received, get latest control metadata if present.
@@ -1072,7 +1181,7 @@ func (nc *Conn) processControlFlow(msg *Msg, s *Subscription, jsi *jsSub) {
 		}
 		// Consumer sequence
 		dseq := tokens[6]
-		ldseq := msg.Header.Get(lastConsumerSeqHdr)
+		ldseq := msg.headerGet(lastConsumerSeqHdr)
 
 		// Detect consumer sequence mismatch and whether
 		// should restart the consumer.
@@ -1107,7 +1216,7 @@ func (js *js) lookupStreamBySubject(subj string) (string, error) {
 	if err != nil {
 		return _EMPTY_, err
 	}
-	resp, err := js.nc.Request(js.apiSubj(apiStreams), j, js.opts.wait)
+	resp, err := js.apiRequest(js.apiSubj(apiStreams), j, js.opts.wait)
 	if err != nil {
 		if err == ErrNoResponders {
 			err = ErrJetStreamNotEnabled
@@ -1345,6 +1454,7 @@ func (sub *Subscription) Fetch(batch int, opts ...PullOpt) ([]*Msg, error) {
 	nc, _ := sub.conn, sub.Subject
 	stream, consumer := sub.jsi.stream, sub.jsi.consumer
 	js := sub.jsi.js
+	resumed := sub.resumed
 
 	ttl := o.ttl
 	if ttl == 0 {
@@ -1364,6 +1474,15 @@ func (sub *Subscription) Fetch(batch int, opts ...PullOpt) ([]*Msg, error) {
 		defer cancel()
 	}
 
+	// Wait for a paused subscription to be resumed, the request expires
+	// otherwise.
+	if resumed != nil {
+		select {
+		case <-resumed:
+		case <-ctx.Done():
+		}
+	}
+
 	// Check if context not done already before making the request.
 	select {
 	case <-ctx.Done():
@@ -1412,7 +1531,7 @@ func (sub *Subscription) Fetch(batch int, opts ...PullOpt) ([]*Msg, error) {
 
 	// In case of only one message, then can already handle with built-in request functions.
 	if batch == 1 {
-		resp, err := nc.oldRequestWithContext(ctx, reqNext, nil, req)
+		resp, err := nc.oldRequestWithContext(ctx, js.nsPrefix(), reqNext, nil, req)
 		if err != nil {
 			return nil, checkCtxErr(err)
 		}
@@ -1428,7 +1547,7 @@ func (sub *Subscription) Fetch(batch int, opts ...PullOpt) ([]*Msg, error) {
 				nr.NoWait = false
 				nr.Expires = expires
 				req, _ = json.Marshal(nr)
-				resp, err = nc.oldRequestWithContext(ctx, reqNext, nil, req)
+				resp, err = nc.oldRequestWithContext(ctx, js.nsPrefix(), reqNext, nil, req)
 				if err != nil {
 					return nil, checkCtxErr(err)
 				}
@@ -1451,10 +1570,10 @@ func (sub *Subscription) Fetch(batch int, opts ...PullOpt) ([]*Msg, error) {
 	// Setup a request where we will wait for the first response
 	// in case of errors, then dispatch the rest of the replies
 	// to the channel.
-	inbox := NewInbox()
+	inbox := js.newInbox()
 
 	mch := make(chan *Msg, batch)
-	s, err := nc.subscribe(inbox, _EMPTY_, nil, mch, true, nil)
+	s, err := nc.nsSubscribe(js.nsPrefix(), inbox, _EMPTY_, nil, mch, true, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -1601,7 +1720,7 @@ func (js *js) getConsumerInfo(stream, consumer string) (*ConsumerInfo, error) {
 
 func (js *js) getConsumerInfoContext(ctx context.Context, stream, consumer string) (*ConsumerInfo, error) {
 	ccInfoSubj := fmt.Sprintf(apiConsumerInfoT, stream, consumer)
-	resp, err := js.nc.RequestWithContext(ctx, js.apiSubj(ccInfoSubj), nil)
+	resp, err := js.apiRequestWithContext(ctx, js.apiSubj(ccInfoSubj), nil)
 	if err != nil {
 		if err == ErrNoResponders {
 			err = ErrJetStreamNotEnabled
@@ -1614,7 +1733,7 @@ func (js *js) getConsumerInfoContext(ctx context.Context, stream, consumer strin
 		return nil, err
 	}
 	if info.Error != nil {
-		return nil, fmt.Errorf("nats: %s", info.Error.Description)
+		return nil, info.Error
 	}
 	return info.ConsumerInfo, nil
 }
@@ -1678,10 +1797,14 @@ func (m *Msg) ackReply(ackType []byte, sync bool, opts ...AckOpt) error {
 	}
 
 	if sync {
+		var ns string
+		if js != nil {
+			ns = js.nsPrefix()
+		}
 		if usesCtx {
-			_, err = nc.RequestWithContext(ctx, m.Reply, ackType)
+			_, err = nc.nsRequest(ctx, ns, m.Reply, nil, ackType, 0)
 		} else {
-			_, err = nc.Request(m.Reply, ackType, wait)
+			_, err = nc.nsRequest(nil, ns, m.Reply, nil, ackType, wait)
 		}
 	} else {
 		err = nc.Publish(m.Reply, ackType)
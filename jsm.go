@@ -16,9 +16,7 @@ package nats
 import (
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"net/http"
 	"strings"
 	"time"
 )
@@ -116,16 +114,45 @@ type ExternalStream struct {
 	DeliverPrefix string `json:"deliver"`
 }
 
-// apiError is included in all API responses if there was an error.
-type apiError struct {
+// APIError is included in all API responses if there was an error, and
+// is the error returned by the JetStream functions in that case.
+type APIError struct {
+	// Code is the HTTP-like status code, such as 404 or 503.
 	Code        int    `json:"code"`
 	Description string `json:"description,omitempty"`
 }
 
+// Errors returned by the JetStream API, to be checked with errors.Is.
+var (
+	ErrStreamNotFound    error = &APIError{Code: 404, Description: "stream not found"}
+	ErrConsumerNotFound  error = &APIError{Code: 404, Description: "consumer not found"}
+	ErrWrongLastSequence error = &APIError{Code: 400, Description: "wrong last sequence"}
+	ErrWrongLastMsgId    error = &APIError{Code: 400, Description: "wrong last msg ID"}
+	ErrStreamMismatch    error = &APIError{Code: 400, Description: "expected stream does not match"}
+)
+
+func (e *APIError) Error() string {
+	return "nats: " + e.Description
+}
+
+// Is returns true if target is an APIError with the same code, unless
+// zero, and a description e starts with, unless empty. Descriptions may
+// carry details, such as "wrong last sequence: 10".
+func (e *APIError) Is(target error) bool {
+	t, ok := target.(*APIError)
+	if !ok || e == nil || t == nil {
+		return false
+	}
+	if t.Code != 0 && t.Code != e.Code {
+		return false
+	}
+	return t.Description == _EMPTY_ || strings.HasPrefix(e.Description, t.Description)
+}
+
 // apiResponse is a standard response from the JetStream JSON API
 type apiResponse struct {
 	Type  string    `json:"type"`
-	Error *apiError `json:"error,omitempty"`
+	Error *APIError `json:"error,omitempty"`
 }
 
 // apiPaged includes variables used to create paged responses from the JSON API
@@ -180,7 +207,7 @@ func (js *js) AccountInfo(opts ...JSOpt) (*AccountInfo, error) {
 		defer cancel()
 	}
 
-	resp, err := js.nc.RequestWithContext(o.ctx, js.apiSubj(apiAccountInfo), nil)
+	resp, err := js.apiRequestWithContext(o.ctx, js.apiSubj(apiAccountInfo), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -189,13 +216,10 @@ func (js *js) AccountInfo(opts ...JSOpt) (*AccountInfo, error) {
 		return nil, err
 	}
 	if info.Error != nil {
-		var err error
 		if strings.Contains(info.Error.Description, "not enabled for") {
-			err = ErrJetStreamNotEnabled
-		} else {
-			err = errors.New(info.Error.Description)
+			return nil, ErrJetStreamNotEnabled
 		}
-		return nil, err
+		return nil, info.Error
 	}
 
 	return &info.AccountInfo, nil
@@ -239,7 +263,7 @@ func (js *js) AddConsumer(stream string, cfg *ConsumerConfig, opts ...JSOpt) (*C
 		ccSubj = fmt.Sprintf(apiConsumerCreateT, stream)
 	}
 
-	resp, err := js.nc.RequestWithContext(o.ctx, js.apiSubj(ccSubj), req)
+	resp, err := js.apiRequestWithContext(o.ctx, js.apiSubj(ccSubj), req)
 	if err != nil {
 		if err == ErrNoResponders {
 			err = ErrJetStreamNotEnabled
@@ -252,7 +276,7 @@ func (js *js) AddConsumer(stream string, cfg *ConsumerConfig, opts ...JSOpt) (*C
 		return nil, err
 	}
 	if info.Error != nil {
-		return nil, errors.New(info.Error.Description)
+		return nil, info.Error
 	}
 	return info.ConsumerInfo, nil
 }
@@ -278,7 +302,7 @@ func (js *js) DeleteConsumer(stream, consumer string, opts ...JSOpt) error {
 	}
 
 	dcSubj := js.apiSubj(fmt.Sprintf(apiConsumerDeleteT, stream, consumer))
-	r, err := js.nc.RequestWithContext(o.ctx, dcSubj, nil)
+	r, err := js.apiRequestWithContext(o.ctx, dcSubj, nil)
 	if err != nil {
 		return err
 	}
@@ -287,7 +311,7 @@ func (js *js) DeleteConsumer(stream, consumer string, opts ...JSOpt) error {
 		return err
 	}
 	if resp.Error != nil {
-		return errors.New(resp.Error.Description)
+		return resp.Error
 	}
 	return nil
 }
@@ -357,7 +381,7 @@ func (c *consumerLister) Next() bool {
 	}
 
 	clSubj := c.js.apiSubj(fmt.Sprintf(apiConsumerListT, c.stream))
-	r, err := c.js.nc.RequestWithContext(ctx, clSubj, req)
+	r, err := c.js.apiRequestWithContext(ctx, clSubj, req)
 	if err != nil {
 		c.err = err
 		return false
@@ -368,7 +392,7 @@ func (c *consumerLister) Next() bool {
 		return false
 	}
 	if resp.Error != nil {
-		c.err = errors.New(resp.Error.Description)
+		c.err = resp.Error
 		return false
 	}
 
@@ -454,7 +478,7 @@ func (c *consumerNamesLister) Next() bool {
 	}
 
 	clSubj := c.js.apiSubj(fmt.Sprintf(apiConsumerNamesT, c.stream))
-	r, err := c.js.nc.RequestWithContext(ctx, clSubj, nil)
+	r, err := c.js.apiRequestWithContext(ctx, clSubj, nil)
 	if err != nil {
 		c.err = err
 		return false
@@ -465,7 +489,7 @@ func (c *consumerNamesLister) Next() bool {
 		return false
 	}
 	if resp.Error != nil {
-		c.err = errors.New(resp.Error.Description)
+		c.err = resp.Error
 		return false
 	}
 
@@ -538,7 +562,7 @@ func (js *js) AddStream(cfg *StreamConfig, opts ...JSOpt) (*StreamInfo, error) {
 	}
 
 	csSubj := js.apiSubj(fmt.Sprintf(apiStreamCreateT, cfg.Name))
-	r, err := js.nc.RequestWithContext(o.ctx, csSubj, req)
+	r, err := js.apiRequestWithContext(o.ctx, csSubj, req)
 	if err != nil {
 		return nil, err
 	}
@@ -547,7 +571,7 @@ func (js *js) AddStream(cfg *StreamConfig, opts ...JSOpt) (*StreamInfo, error) {
 		return nil, err
 	}
 	if resp.Error != nil {
-		return nil, errors.New(resp.Error.Description)
+		return nil, resp.Error
 	}
 	return resp.StreamInfo, nil
 }
@@ -564,7 +588,7 @@ func (js *js) StreamInfo(stream string, opts ...JSOpt) (*StreamInfo, error) {
 	}
 
 	csSubj := js.apiSubj(fmt.Sprintf(apiStreamInfoT, stream))
-	r, err := js.nc.RequestWithContext(o.ctx, csSubj, nil)
+	r, err := js.apiRequestWithContext(o.ctx, csSubj, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -573,7 +597,7 @@ func (js *js) StreamInfo(stream string, opts ...JSOpt) (*StreamInfo, error) {
 		return nil, err
 	}
 	if resp.Error != nil {
-		return nil, errors.New(resp.Error.Description)
+		return nil, resp.Error
 	}
 	return resp.StreamInfo, nil
 }
@@ -644,7 +668,7 @@ func (js *js) UpdateStream(cfg *StreamConfig, opts ...JSOpt) (*StreamInfo, error
 	}
 
 	usSubj := js.apiSubj(fmt.Sprintf(apiStreamUpdateT, cfg.Name))
-	r, err := js.nc.RequestWithContext(o.ctx, usSubj, req)
+	r, err := js.apiRequestWithContext(o.ctx, usSubj, req)
 	if err != nil {
 		return nil, err
 	}
@@ -653,7 +677,7 @@ func (js *js) UpdateStream(cfg *StreamConfig, opts ...JSOpt) (*StreamInfo, error
 		return nil, err
 	}
 	if resp.Error != nil {
-		return nil, errors.New(resp.Error.Description)
+		return nil, resp.Error
 	}
 	return resp.StreamInfo, nil
 }
@@ -679,7 +703,7 @@ func (js *js) DeleteStream(name string, opts ...JSOpt) error {
 	}
 
 	dsSubj := js.apiSubj(fmt.Sprintf(apiStreamDeleteT, name))
-	r, err := js.nc.RequestWithContext(o.ctx, dsSubj, nil)
+	r, err := js.apiRequestWithContext(o.ctx, dsSubj, nil)
 	if err != nil {
 		return err
 	}
@@ -688,7 +712,7 @@ func (js *js) DeleteStream(name string, opts ...JSOpt) error {
 		return err
 	}
 	if resp.Error != nil {
-		return errors.New(resp.Error.Description)
+		return resp.Error
 	}
 	return nil
 }
@@ -701,7 +725,7 @@ type apiMsgGetRequest struct {
 type RawStreamMsg struct {
 	Subject  string
 	Sequence uint64
-	Header   http.Header
+	Header   Header
 	Data     []byte
 	Time     time.Time
 }
@@ -742,7 +766,7 @@ func (js *js) GetMsg(name string, seq uint64, opts ...JSOpt) (*RawStreamMsg, err
 	}
 
 	dsSubj := js.apiSubj(fmt.Sprintf(apiMsgGetT, name))
-	r, err := js.nc.RequestWithContext(o.ctx, dsSubj, req)
+	r, err := js.apiRequestWithContext(o.ctx, dsSubj, req)
 	if err != nil {
 		return nil, err
 	}
@@ -752,12 +776,12 @@ func (js *js) GetMsg(name string, seq uint64, opts ...JSOpt) (*RawStreamMsg, err
 		return nil, err
 	}
 	if resp.Error != nil {
-		return nil, errors.New(resp.Error.Description)
+		return nil, resp.Error
 	}
 
 	msg := resp.Message
 
-	var hdr http.Header
+	var hdr Header
 	if msg.Header != nil {
 		hdr, err = decodeHeadersMsg(msg.Header)
 		if err != nil {
@@ -804,7 +828,7 @@ func (js *js) DeleteMsg(name string, seq uint64, opts ...JSOpt) error {
 	}
 
 	dsSubj := js.apiSubj(fmt.Sprintf(apiMsgDeleteT, name))
-	r, err := js.nc.RequestWithContext(o.ctx, dsSubj, req)
+	r, err := js.apiRequestWithContext(o.ctx, dsSubj, req)
 	if err != nil {
 		return err
 	}
@@ -813,7 +837,7 @@ func (js *js) DeleteMsg(name string, seq uint64, opts ...JSOpt) error {
 		return err
 	}
 	if resp.Error != nil {
-		return errors.New(resp.Error.Description)
+		return resp.Error
 	}
 	return nil
 }
@@ -835,7 +859,7 @@ func (js *js) PurgeStream(name string, opts ...JSOpt) error {
 	}
 
 	psSubj := js.apiSubj(fmt.Sprintf(apiStreamPurgeT, name))
-	r, err := js.nc.RequestWithContext(o.ctx, psSubj, nil)
+	r, err := js.apiRequestWithContext(o.ctx, psSubj, nil)
 	if err != nil {
 		return err
 	}
@@ -844,7 +868,7 @@ func (js *js) PurgeStream(name string, opts ...JSOpt) error {
 		return err
 	}
 	if resp.Error != nil {
-		return errors.New(resp.Error.Description)
+		return resp.Error
 	}
 	return nil
 }
@@ -900,7 +924,7 @@ func (s *streamLister) Next() bool {
 	}
 
 	slSubj := s.js.apiSubj(apiStreamList)
-	r, err := s.js.nc.RequestWithContext(ctx, slSubj, req)
+	r, err := s.js.apiRequestWithContext(ctx, slSubj, req)
 	if err != nil {
 		s.err = err
 		return false
@@ -911,7 +935,7 @@ func (s *streamLister) Next() bool {
 		return false
 	}
 	if resp.Error != nil {
-		s.err = errors.New(resp.Error.Description)
+		s.err = resp.Error
 		return false
 	}
 
@@ -984,7 +1008,7 @@ func (l *streamNamesLister) Next() bool {
 		defer cancel()
 	}
 
-	r, err := l.js.nc.RequestWithContext(ctx, l.js.apiSubj(apiStreams), nil)
+	r, err := l.js.apiRequestWithContext(ctx, l.js.apiSubj(apiStreams), nil)
 	if err != nil {
 		l.err = err
 		return false
@@ -995,7 +1019,7 @@ func (l *streamNamesLister) Next() bool {
 		return false
 	}
 	if resp.Error != nil {
-		l.err = errors.New(resp.Error.Description)
+		l.err = resp.Error
 		return false
 	}
 
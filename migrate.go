@@ -0,0 +1,266 @@
+// Copyright 2021 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import (
+	"net"
+	"time"
+)
+
+// migration tracks the move away from a server in lame duck mode.
+type migration struct {
+	// conn is the connection being retired, nil until the connection
+	// to the new server is established.
+	conn net.Conn
+	// done is closed once the readLoop of conn has returned.
+	done chan struct{}
+	// over is closed once the migration is over, successful or not.
+	over chan struct{}
+}
+
+func newMigration() *migration {
+	return &migration{over: make(chan struct{})}
+}
+
+// endMigration marks the migration in progress as over.
+// Lock is assumed held.
+func (nc *Conn) endMigration() {
+	close(nc.mig.over)
+	nc.mig = nil
+}
+
+// isRetired returns true if conn is the connection we are moving away from.
+func (nc *Conn) isRetired(conn net.Conn) bool {
+	nc.mu.RLock()
+	defer nc.mu.RUnlock()
+	return nc.mig != nil && nc.mig.conn == conn
+}
+
+// migrate moves the connection to another server of the pool, or to a
+// new connection to the same server if sameServer is true, without going
+// through a disconnect. It is make-before-break:
+//
+//   - a connection to the new server is established, without holding
+//     the lock so that the connection can be used meanwhile,
+//   - subscriptions are replayed on it, after which publications go to
+//     the new server,
+//   - the old server is sent a PING, and we keep reading from it until
+//     the matching PONG, so that messages it already sent are delivered,
+//   - only then is the old connection closed and the new one read from.
+//
+// Messages matching a subscription may be received twice while both
+// connections have interest. If no server can be reached, the connection
+// stays where it is and the error of the last server tried is returned.
+func (nc *Conn) migrate(sameServer bool) error {
+	nc.mu.Lock()
+	m := nc.mig
+	switch {
+	case nc.isClosed():
+		nc.endMigration()
+		nc.mu.Unlock()
+		return ErrConnectionClosed
+	case nc.status != CONNECTED:
+		nc.endMigration()
+		nc.mu.Unlock()
+		return ErrConnectionReconnecting
+	case nc.Opts.InProcessServer != nil && !sameServer:
+		nc.endMigration()
+		nc.mu.Unlock()
+		return ErrNoServers
+	}
+	oldConn, oldSrv := nc.conn, nc.current
+
+	// The pool may change while we dial, so walk a copy.
+	candidates := make([]*srv, 0, len(nc.srvPool))
+	if sameServer && !oldSrv.removed {
+		candidates = append(candidates, oldSrv)
+	}
+	for _, s := range nc.srvPool {
+		if s != oldSrv && !s.removed {
+			candidates = append(candidates, s)
+		}
+	}
+	nc.mu.Unlock()
+
+	var (
+		target *srv
+		c      *Conn
+		err    error
+	)
+	for _, s := range candidates {
+		if c, err = nc.dialServer(s); err == nil {
+			target = s
+			break
+		}
+	}
+
+	if target == nil && err == nil {
+		err = ErrNoServers
+	}
+
+	nc.mu.Lock()
+	// Give up if no server could be reached, or if the connection was
+	// closed or lost while dialing.
+	switch {
+	case nc.isClosed():
+		err = ErrConnectionClosed
+	case nc.status != CONNECTED || nc.conn != oldConn:
+		err = ErrConnectionReconnecting
+	}
+	// Everything published so far goes to the old server.
+	oldBw := nc.bw
+	if err == nil {
+		err = oldBw.Flush()
+	}
+	if err != nil {
+		nc.endMigration()
+		nc.mu.Unlock()
+		if target != nil {
+			c.conn.Close()
+		}
+		return err
+	}
+	newConn := c.conn
+	nc.conn, nc.bw, nc.vw, nc.ob = c.conn, c.bw, c.vw, c.ob
+	nc.current = target
+	nc.updateInfo(c.info)
+	nc.cjwt = c.cjwt
+	nc.setUserJWT(c.cjwt)
+	target.didConnect = true
+	target.reconnects = 0
+	target.lastErr = nil
+	target.lastFailure = nil
+	target.handshake = time.Since(target.lastAttempt)
+
+	// Keep the server we leave as the last one to try.
+	if target != oldSrv {
+		oldSrv.lastDisconnect = time.Now()
+		for i, s := range nc.srvPool {
+			if s == oldSrv {
+				copy(nc.srvPool[i:], nc.srvPool[i+1:])
+				nc.srvPool[len(nc.srvPool)-1] = oldSrv
+				break
+			}
+		}
+	}
+
+	// Mark the end of what the old server has for us. Its PONG comes
+	// after any pending flush calls, and before the PONGs of the new
+	// server since those are only read once the old connection is done.
+	marker := make(chan struct{}, 1)
+	nc.pongs = append(nc.pongs, marker)
+	oldBw.WriteString(pingProto)
+	oldBw.Flush()
+
+	// Replay subscriptions on the new server, publications now go there.
+	nc.resendSubscriptions()
+	nc.sendPing(nil)
+	nc.pout = 0
+
+	m.conn = oldConn
+	m.done = make(chan struct{})
+
+	// The old flusher has its own copy of the kick channel, closing it
+	// makes it return without taking kicks meant for the new one.
+	fch := nc.fch
+	nc.fch = make(chan struct{}, flushChanSize)
+	close(fch)
+	nc.wg.Add(1)
+	go nc.flusher()
+	nc.mu.Unlock()
+
+	wait := nc.Opts.Timeout
+	if wait <= 0 {
+		wait = DefaultTimeout
+	}
+	timer := time.NewTimer(wait)
+	select {
+	case <-marker:
+	case <-m.done:
+	case <-timer.C:
+	}
+	timer.Stop()
+
+	nc.mu.Lock()
+	// If the PONG did not make it, release the flush calls that were
+	// waiting on the old server and drop its entries.
+	for i, ch := range nc.pongs {
+		if ch == marker {
+			for _, c := range nc.pongs[:i] {
+				if c != nil {
+					close(c)
+				}
+			}
+			nc.pongs = nc.pongs[i+1:]
+			break
+		}
+	}
+	oldConn.Close()
+	nc.mu.Unlock()
+
+	// The parse state is shared, wait for the old readLoop to be done
+	// before starting the new one.
+	<-m.done
+
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	nc.endMigration()
+	// Closed, or lost, while draining the old connection.
+	if nc.isClosed() {
+		return ErrConnectionClosed
+	}
+	if nc.conn != newConn || nc.status != CONNECTED {
+		return ErrConnectionReconnecting
+	}
+	nc.wg.Add(1)
+	go nc.readLoop()
+	if nc.Opts.ReconnectedCB != nil {
+		nc.ach.push(func() { nc.Opts.ReconnectedCB(nc) })
+	}
+	return nil
+}
+
+// dialServer connects and authenticates to s on a Conn of its own, so
+// that the lock of nc is not held meanwhile. The returned Conn holds the
+// socket, its buffered writer and the INFO of the server.
+func (nc *Conn) dialServer(s *srv) (*Conn, error) {
+	nc.mu.Lock()
+	s.lastAttempt = time.Now()
+	c := &Conn{Opts: nc.Opts, proxy: nc.proxy, urls: make(map[string]struct{}), initc: true}
+	cur := *s
+	nc.mu.Unlock()
+
+	c.current = &cur
+	// The expiry of the credentials is tracked by nc once moved over.
+	c.Opts.CredentialsExpiringCB = nil
+	c.Opts.CredentialsRefreshCB = nil
+	err := c.dial()
+	if err == nil {
+		c.bw = c.newBuffer()
+		if err = c.handshake(); err != nil {
+			c.conn.Close()
+		}
+	}
+
+	nc.mu.Lock()
+	s.lastErr, s.authFailures, s.rtt = cur.lastErr, cur.authFailures, cur.rtt
+	if err != nil {
+		s.lastFailure = err
+	}
+	nc.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
@@ -0,0 +1,277 @@
+// Copyright 2021 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Namespace is a view of a connection limited to the subjects under a
+// prefix, such as "tenant.acme", for code that should not have to, or be
+// able to, deal with the subjects of others.
+//
+// Subjects given to a namespace are relative to its prefix, which is
+// prepended on the way out, and stripped from the subject of the messages
+// received. Wildcards only match within the namespace, the subjects given
+// must be valid on their own, and the ones published to literal. Inboxes
+// are in the namespace too, so that responses stay within it. The reply
+// subject of the messages received is left as is, for Msg.Respond to
+// reach the requester, and must not be published to through a namespace.
+//
+// Requests of a namespace use an inbox and a subscription per request, as
+// with the UseOldRequestStyle option.
+type Namespace struct {
+	nc *Conn
+	// With a trailing ".".
+	prefix string
+}
+
+// WithNamespace returns a view of the connection limited to the subjects
+// under prefix, which must be a literal subject. See Namespace.
+func (nc *Conn) WithNamespace(prefix string) (*Namespace, error) {
+	if nc == nil {
+		return nil, ErrInvalidConnection
+	}
+	prefix = strings.TrimSuffix(prefix, ".")
+	if prefix == _EMPTY_ || badSubject(prefix) || strings.ContainsAny(prefix, "*>") {
+		return nil, ErrBadNamespace
+	}
+	return &Namespace{nc: nc, prefix: prefix + "."}, nil
+}
+
+// WithNamespace returns a view of the connection limited to the subjects
+// under prefix within the namespace.
+func (ns *Namespace) WithNamespace(prefix string) (*Namespace, error) {
+	prefix = strings.TrimSuffix(prefix, ".")
+	if prefix == _EMPTY_ || badSubject(prefix) || strings.ContainsAny(prefix, "*>") {
+		return nil, ErrBadNamespace
+	}
+	return &Namespace{nc: ns.nc, prefix: ns.prefix + prefix + "."}, nil
+}
+
+// Conn returns the connection of the namespace.
+func (ns *Namespace) Conn() *Conn {
+	return ns.nc
+}
+
+// Prefix returns the prefix of the namespace.
+func (ns *Namespace) Prefix() string {
+	return strings.TrimSuffix(ns.prefix, ".")
+}
+
+// subject returns the subject of subj, relative to the namespace, on the
+// connection. Wildcards are only accepted if wildcards is set, as tokens
+// of their own, with ">" only as the last token.
+func (ns *Namespace) subject(subj string, wildcards bool) (string, error) {
+	if subj == _EMPTY_ || badSubject(subj) {
+		return _EMPTY_, ErrBadSubject
+	}
+	tokens := strings.Split(subj, ".")
+	for i, t := range tokens {
+		if !strings.ContainsAny(t, "*>") {
+			continue
+		}
+		if !wildcards || (t != "*" && t != ">") || (t == ">" && i != len(tokens)-1) {
+			return _EMPTY_, ErrBadSubject
+		}
+	}
+	return ns.prefix + subj, nil
+}
+
+// NewInbox returns a new inbox in the namespace, relative to it.
+func (ns *Namespace) NewInbox() string {
+	return ns.nc.newInbox()
+}
+
+// Publish publishes the data argument to the given subject of the
+// namespace.
+func (ns *Namespace) Publish(subj string, data []byte) error {
+	return ns.PublishRequest(subj, _EMPTY_, data)
+}
+
+// PublishRequest publishes the data argument to the given subject of the
+// namespace, with reply, in the namespace too, as the reply subject.
+func (ns *Namespace) PublishRequest(subj, reply string, data []byte) error {
+	return ns.PublishMsg(&Msg{Subject: subj, Reply: reply, Data: data})
+}
+
+// PublishMsg publishes the Msg structure, the subject and reply subject of
+// which are in the namespace.
+func (ns *Namespace) PublishMsg(m *Msg) error {
+	if m == nil {
+		return ErrInvalidMsg
+	}
+	subj, err := ns.subject(m.Subject, false)
+	if err != nil {
+		return err
+	}
+	reply := m.Reply
+	if reply != _EMPTY_ {
+		if reply, err = ns.subject(reply, false); err != nil {
+			return err
+		}
+	}
+	return ns.nc.PublishMsg(&Msg{Subject: subj, Reply: reply, Header: m.Header, Data: m.Data})
+}
+
+// Request sends a request to the given subject of the namespace and
+// returns the response, or an error, including a timeout if no response
+// was received in time.
+func (ns *Namespace) Request(subj string, data []byte, timeout time.Duration) (*Msg, error) {
+	return ns.request(nil, subj, nil, data, timeout)
+}
+
+// RequestWithContext sends a request to the given subject of the namespace
+// and returns the response, or an error, including the one of the context
+// if done before a response was received.
+func (ns *Namespace) RequestWithContext(ctx context.Context, subj string, data []byte) (*Msg, error) {
+	if ctx == nil {
+		return nil, ErrInvalidContext
+	}
+	return ns.request(ctx, subj, nil, data, 0)
+}
+
+// RequestMsg sends the request msg, the subject of which is in the
+// namespace, including optional headers, and returns the response.
+func (ns *Namespace) RequestMsg(msg *Msg, timeout time.Duration) (*Msg, error) {
+	hdr, err := ns.headerBytes(msg)
+	if err != nil {
+		return nil, err
+	}
+	return ns.request(nil, msg.Subject, hdr, msg.Data, timeout)
+}
+
+// RequestMsgWithContext sends the request msg, the subject of which is in
+// the namespace, including optional headers, and returns the response.
+func (ns *Namespace) RequestMsgWithContext(ctx context.Context, msg *Msg) (*Msg, error) {
+	if ctx == nil {
+		return nil, ErrInvalidContext
+	}
+	hdr, err := ns.headerBytes(msg)
+	if err != nil {
+		return nil, err
+	}
+	return ns.request(ctx, msg.Subject, hdr, msg.Data, 0)
+}
+
+func (ns *Namespace) headerBytes(msg *Msg) ([]byte, error) {
+	if msg == nil {
+		return nil, ErrInvalidMsg
+	}
+	if len(msg.Header) == 0 {
+		return nil, nil
+	}
+	if !ns.nc.info.Headers {
+		return nil, ErrHeadersNotSupported
+	}
+	return msg.headerBytes()
+}
+
+// request sends a request to the given subject of the namespace, waiting
+// for the response until ctx is done, if given, or else for timeout.
+func (ns *Namespace) request(ctx context.Context, subj string, hdr, data []byte, timeout time.Duration) (*Msg, error) {
+	subj, err := ns.subject(subj, false)
+	if err != nil {
+		return nil, err
+	}
+	return ns.nc.nsRequest(ctx, ns.prefix, subj, hdr, data, timeout)
+}
+
+// nsRequest sends a request to subj, a subject on the connection, with an
+// inbox in the namespace ns, if any, waiting for the response until ctx is
+// done, if given, or else for timeout.
+func (nc *Conn) nsRequest(ctx context.Context, ns, subj string, hdr, data []byte, timeout time.Duration) (*Msg, error) {
+	if ns == _EMPTY_ {
+		if ctx != nil {
+			return nc.requestWithContext(ctx, subj, hdr, data)
+		}
+		return nc.request(subj, hdr, data, timeout)
+	}
+	var m *Msg
+	var err error
+	if ctx != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		m, err = nc.oldRequestWithContext(ctx, ns, subj, hdr, data)
+	} else {
+		m, err = nc.oldRequest(ns, subj, hdr, data, timeout)
+	}
+	// Check for no responder status.
+	if err == nil && len(m.Data) == 0 && m.headerGet(statusHdr) == noResponders {
+		m, err = nil, ErrNoResponders
+	}
+	return m, err
+}
+
+// Subscribe expresses interest in the given subject of the namespace,
+// see Conn.Subscribe.
+func (ns *Namespace) Subscribe(subj string, cb MsgHandler) (*Subscription, error) {
+	return ns.subscribe(subj, _EMPTY_, cb, nil, false)
+}
+
+// QueueSubscribe expresses interest in the given subject of the namespace
+// as a member of the queue group, see Conn.QueueSubscribe.
+func (ns *Namespace) QueueSubscribe(subj, queue string, cb MsgHandler) (*Subscription, error) {
+	return ns.subscribe(subj, queue, cb, nil, false)
+}
+
+// SubscribeSync expresses interest in the given subject of the namespace,
+// for messages to be received with NextMsg.
+func (ns *Namespace) SubscribeSync(subj string) (*Subscription, error) {
+	return ns.subscribe(subj, _EMPTY_, nil, make(chan *Msg, ns.nc.Opts.SubChanLen), true)
+}
+
+// QueueSubscribeSync expresses interest in the given subject of the
+// namespace as a member of the queue group, for messages to be received
+// with NextMsg.
+func (ns *Namespace) QueueSubscribeSync(subj, queue string) (*Subscription, error) {
+	return ns.subscribe(subj, queue, nil, make(chan *Msg, ns.nc.Opts.SubChanLen), true)
+}
+
+// ChanSubscribe expresses interest in the given subject of the namespace,
+// for messages to be placed on the channel, see Conn.ChanSubscribe.
+func (ns *Namespace) ChanSubscribe(subj string, ch chan *Msg) (*Subscription, error) {
+	return ns.subscribe(subj, _EMPTY_, nil, ch, false)
+}
+
+// ChanQueueSubscribe expresses interest in the given subject of the
+// namespace as a member of the queue group, for messages to be placed on
+// the channel.
+func (ns *Namespace) ChanQueueSubscribe(subj, queue string, ch chan *Msg) (*Subscription, error) {
+	return ns.subscribe(subj, queue, nil, ch, false)
+}
+
+func (ns *Namespace) subscribe(subj, queue string, cb MsgHandler, ch chan *Msg, isSync bool) (*Subscription, error) {
+	subj, err := ns.subject(subj, true)
+	if err != nil {
+		return nil, err
+	}
+	return ns.nc.nsSubscribe(ns.prefix, subj, queue, cb, ch, isSync, nil)
+}
+
+// JetStream returns a JetStreamContext publishing and subscribing to the
+// subjects of the namespace, with inboxes in it, stripping the prefix from
+// the subject of the messages received. Streams and consumers, and their
+// management, are not namespaced, the subjects of streams and consumers
+// being the ones on the connection.
+func (ns *Namespace) JetStream(opts ...JSOpt) (JetStreamContext, error) {
+	nsOpt := jsOptFn(func(o *jsOpts) error {
+		o.ns = ns
+		return nil
+	})
+	return ns.nc.JetStream(append(opts[:len(opts):len(opts)], nsOpt)...)
+}
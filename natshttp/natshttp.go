@@ -0,0 +1,303 @@
+// Copyright 2021 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package natshttp carries HTTP requests and responses over NATS.
+//
+// A Transport is an http.RoundTripper that sends each request as a NATS
+// request and turns the reply back into an *http.Response. Serve answers
+// those requests with a regular http.Handler from a queue subscription.
+// The method, path and host of the request travel as NATS headers next to
+// the HTTP headers, and the status code of the response travels back the
+// same way.
+package natshttp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Headers used to carry the parts of an HTTP request or response that
+// do not map to a regular HTTP header.
+const (
+	MethodHdr = "Nats-Http-Method"
+	PathHdr   = "Nats-Http-Path"
+	HostHdr   = "Nats-Http-Host"
+	StatusHdr = "Nats-Http-Status"
+)
+
+// DefaultTimeout is used by a Transport when neither its Timeout nor the
+// request context set a deadline.
+const DefaultTimeout = 10 * time.Second
+
+var (
+	ErrNoSubject       = errors.New("natshttp: no subject for request")
+	ErrRequestTooLarge = errors.New("natshttp: request body exceeds maximum payload")
+)
+
+// Transport is an http.RoundTripper that sends requests over NATS.
+type Transport struct {
+	// Conn is the connection used to send the requests.
+	Conn *nats.Conn
+
+	// Subject is the subject requests are sent to.
+	Subject string
+
+	// SubjectFunc, if set, picks the subject for each request and
+	// takes precedence over Subject.
+	SubjectFunc func(r *http.Request) string
+
+	// Timeout bounds how long to wait for a reply. When zero, the
+	// deadline of the request context is used, or DefaultTimeout
+	// if the context has none.
+	Timeout time.Duration
+}
+
+// RoundTrip implements http.RoundTripper. A request that times out, with
+// Timeout or DefaultTimeout, gets a 504 Gateway Timeout response, and one
+// without anyone serving the subject a 503 Service Unavailable response.
+// The error of the request context is returned if it is done first.
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.Body != nil {
+		defer r.Body.Close()
+	}
+	if t.Conn == nil {
+		return nil, nats.ErrInvalidConnection
+	}
+	subj := t.Subject
+	if t.SubjectFunc != nil {
+		subj = t.SubjectFunc(r)
+	}
+	if subj == "" {
+		return nil, ErrNoSubject
+	}
+
+	var body []byte
+	if r.Body != nil {
+		// Read one byte past the limit so we can tell an exact fit
+		// from a body that is too large.
+		max := t.Conn.MaxPayload()
+		b, err := ioutil.ReadAll(io.LimitReader(r.Body, max+1))
+		if err != nil {
+			return nil, err
+		}
+		if int64(len(b)) > max {
+			return nil, ErrRequestTooLarge
+		}
+		body = b
+	}
+
+	m := nats.NewMsg(subj)
+	m.Data = body
+	for k, v := range r.Header {
+		m.Header[k] = v
+	}
+	m.Header.Set(MethodHdr, r.Method)
+	m.Header.Set(PathHdr, r.URL.RequestURI())
+	host := r.Host
+	if host == "" {
+		host = r.URL.Host
+	}
+	if host != "" {
+		m.Header.Set(HostHdr, host)
+	}
+
+	ctx := r.Context()
+	if t.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.Timeout)
+		defer cancel()
+	} else if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultTimeout)
+		defer cancel()
+	}
+
+	reply, err := t.Conn.RequestMsgWithContext(ctx, m)
+	switch {
+	case err == nats.ErrMaxPayload:
+		return nil, ErrRequestTooLarge
+	case err == nats.ErrNoResponders:
+		return errorResponse(r, http.StatusServiceUnavailable, "natshttp: no responders available for request"), nil
+	case err == context.DeadlineExceeded && r.Context().Err() == nil:
+		return errorResponse(r, http.StatusGatewayTimeout, "natshttp: timeout waiting for response"), nil
+	case err != nil:
+		return nil, err
+	}
+	return decodeResponse(r, reply)
+}
+
+// errorResponse returns a response with the given status code, generated
+// by the Transport itself, with msg as body.
+func errorResponse(r *http.Request, code int, msg string) *http.Response {
+	header := make(http.Header)
+	header.Set("Content-Type", "text/plain; charset=utf-8")
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", code, http.StatusText(code)),
+		StatusCode:    code,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(strings.NewReader(msg)),
+		ContentLength: int64(len(msg)),
+		Request:       r,
+	}
+}
+
+func decodeResponse(r *http.Request, m *nats.Msg) (*http.Response, error) {
+	code := http.StatusOK
+	if s := m.Header.Get(StatusHdr); s != "" {
+		c, err := strconv.Atoi(s)
+		if err != nil || c < 100 || c > 999 {
+			return nil, fmt.Errorf("natshttp: invalid status code %q", s)
+		}
+		code = c
+	}
+	resp := &http.Response{
+		Status:        fmt.Sprintf("%d %s", code, http.StatusText(code)),
+		StatusCode:    code,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        make(http.Header, len(m.Header)),
+		Body:          ioutil.NopCloser(bytes.NewReader(m.Data)),
+		ContentLength: int64(len(m.Data)),
+		Request:       r,
+	}
+	// NATS headers are case-sensitive, HTTP ones are not.
+	for k, v := range m.Header {
+		k = http.CanonicalHeaderKey(k)
+		if isReserved(k) {
+			continue
+		}
+		resp.Header[k] = append(resp.Header[k], v...)
+	}
+	return resp, nil
+}
+
+// Serve answers HTTP requests sent by a Transport on subject with h.
+// Requests are spread across all servers sharing the same queue group.
+// Responses whose body does not fit in the maximum payload are replaced
+// by a 502 Bad Gateway. Should h panic, a 500 Internal Server Error is
+// sent back and the panic is reported to the async error handler of nc
+// as a *nats.PanicError.
+func Serve(nc *nats.Conn, subject, queue string, h http.Handler) (*nats.Subscription, error) {
+	if h == nil {
+		return nil, errors.New("natshttp: nil handler")
+	}
+	return nc.QueueSubscribe(subject, queue, func(m *nats.Msg) {
+		if m.Reply == "" {
+			return
+		}
+		w := &responseWriter{header: make(http.Header)}
+		r, err := decodeRequest(m)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else {
+			served := false
+			nats.HandlePanics(func(*nats.Msg) {
+				h.ServeHTTP(w, r)
+				served = true
+			})(m)
+			if !served {
+				// Drop whatever the handler wrote before panicking.
+				w = &responseWriter{header: make(http.Header)}
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			}
+		}
+		reply := w.msg(m.Reply)
+		if err := m.RespondMsg(reply); err == nats.ErrMaxPayload {
+			reply = nats.NewMsg(m.Reply)
+			reply.Header.Set(StatusHdr, strconv.Itoa(http.StatusBadGateway))
+			reply.Data = []byte("natshttp: response body exceeds maximum payload")
+			m.RespondMsg(reply)
+		}
+	})
+}
+
+func decodeRequest(m *nats.Msg) (*http.Request, error) {
+	method := m.Header.Get(MethodHdr)
+	if method == "" {
+		method = http.MethodGet
+	}
+	path := m.Header.Get(PathHdr)
+	if path == "" {
+		path = "/"
+	}
+	r, err := http.NewRequest(method, path, bytes.NewReader(m.Data))
+	if err != nil {
+		return nil, err
+	}
+	r.RequestURI = path
+	r.Host = m.Header.Get(HostHdr)
+	// NATS headers are case-sensitive, HTTP ones are not.
+	for k, v := range m.Header {
+		k = http.CanonicalHeaderKey(k)
+		if isReserved(k) {
+			continue
+		}
+		r.Header[k] = append(r.Header[k], v...)
+	}
+	return r, nil
+}
+
+func isReserved(k string) bool {
+	return strings.HasPrefix(k, "Nats-Http-")
+}
+
+// responseWriter collects the response of a handler so it can be sent
+// back as a single reply.
+type responseWriter struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func (w *responseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(b)
+}
+
+func (w *responseWriter) msg(subj string) *nats.Msg {
+	m := nats.NewMsg(subj)
+	for k, v := range w.header {
+		m.Header[k] = v
+	}
+	code := w.code
+	if code == 0 {
+		code = http.StatusOK
+	}
+	m.Header.Set(StatusHdr, strconv.Itoa(code))
+	m.Data = w.body.Bytes()
+	return m
+}
@@ -0,0 +1,236 @@
+// Copyright 2021 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// outbound queues what the bufio writer flushes, and writes it to the
+// socket from its own Go routine, so that the connection lock is not
+// held while waiting on the network. It is used when MaxOutboundBytes
+// is set, for publishers to wait for the queue to drain.
+type outbound struct {
+	mu  sync.Mutex
+	w   io.Writer
+	err error
+
+	// buf is what is left to write, spare the buffer last written.
+	buf   []byte
+	spare []byte
+
+	// size is the number of bytes queued or being written.
+	size int
+
+	// writing is true while the writer Go routine runs.
+	writing bool
+
+	// room is closed, and replaced, every time bytes are written.
+	room chan struct{}
+}
+
+func newOutbound(w io.Writer) *outbound {
+	return &outbound{w: w, room: make(chan struct{})}
+}
+
+// Write implements the io.Writer interface. It queues p and starts the
+// writer Go routine if not running. An error is returned if a previous
+// write to the socket failed.
+func (o *outbound) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.err != nil {
+		return 0, o.err
+	}
+	o.buf = append(o.buf, p...)
+	o.size += len(p)
+	if !o.writing {
+		o.writing = true
+		go o.writeLoop()
+	}
+	return len(p), nil
+}
+
+// writeLoop writes the queue to the socket until it is empty.
+func (o *outbound) writeLoop() {
+	o.mu.Lock()
+	for len(o.buf) > 0 {
+		b := o.buf
+		o.buf, o.spare = o.spare[:0], nil
+		o.mu.Unlock()
+
+		_, err := o.w.Write(b)
+
+		o.mu.Lock()
+		o.spare = b
+		o.size -= len(b)
+		if err != nil {
+			// What is left will never make it.
+			o.err = err
+			o.buf, o.spare, o.size = nil, nil, 0
+		}
+		o.notify()
+	}
+	o.writing = false
+	o.mu.Unlock()
+}
+
+// notify wakes up everyone waiting for room. Lock is assumed held.
+func (o *outbound) notify() {
+	close(o.room)
+	o.room = make(chan struct{})
+}
+
+// pending returns the number of bytes not yet written to the socket, and
+// a channel closed once some are.
+func (o *outbound) pending() (int, <-chan struct{}) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.size, o.room
+}
+
+// flush waits for the queue to be written to the socket.
+func (o *outbound) flush() error {
+	for {
+		o.mu.Lock()
+		size, room, err := o.size, o.room, o.err
+		o.mu.Unlock()
+		if size == 0 || err != nil {
+			return err
+		}
+		<-room
+	}
+}
+
+// flushOutbound writes what is buffered to the socket, waiting for the
+// outbound queue to drain if any. Lock is assumed held.
+func (nc *Conn) flushOutbound() error {
+	if err := nc.bw.Flush(); err != nil {
+		return err
+	}
+	if nc.ob != nil {
+		return nc.ob.flush()
+	}
+	return nil
+}
+
+// MaxOutboundBytes is an Option to limit the number of bytes buffered to
+// be sent to the server. Once reached, publish operations wait for the
+// buffer to drain for as long as set by OutboundFullWait, and then return
+// ErrOutboundFull.
+func MaxOutboundBytes(max int) Option {
+	return func(o *Options) error {
+		if max < 0 {
+			return ErrBadOutboundLimit
+		}
+		o.MaxOutboundBytes = max
+		return nil
+	}
+}
+
+// OutboundFullWait is an Option to set how long publish operations wait
+// for room in the outbound buffer limited by MaxOutboundBytes. Zero, the
+// default, means they fail right away, and a negative value that they
+// wait for as long as it takes.
+func OutboundFullWait(wait time.Duration) Option {
+	return func(o *Options) error {
+		o.OutboundFullWait = wait
+		return nil
+	}
+}
+
+// outboundRoom waits, if needed, for the outbound buffer to have room for
+// a message of size bytes. The wait is bound by ctx, or by the
+// OutboundFullWait option if ctx is nil. A message is always let through
+// when nothing is buffered, whatever its size. Lock is assumed held, and
+// is released while waiting.
+func (nc *Conn) outboundRoom(ctx context.Context, size int) error {
+	var (
+		start time.Time
+		timer *time.Timer
+		done  <-chan struct{}
+	)
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+		if !start.IsZero() {
+			nc.OutboundBlocked++
+			nc.OutboundBlockedTime += time.Since(start)
+		}
+	}()
+
+	for {
+		// Nothing to wait for while reconnecting, ReconnectBufSize applies.
+		ob := nc.ob
+		if ob == nil || nc.isReconnecting() {
+			return nil
+		}
+		buffered := nc.bw.Buffered()
+		queued, room := ob.pending()
+		if buffered+queued == 0 || buffered+queued+size <= nc.Opts.MaxOutboundBytes {
+			return nil
+		}
+		if start.IsZero() {
+			wait := nc.Opts.OutboundFullWait
+			if ctx == nil && wait == 0 {
+				nc.OutboundFull++
+				return ErrOutboundFull
+			}
+			start = time.Now()
+			if ctx != nil {
+				done = ctx.Done()
+			} else if wait > 0 {
+				timer = time.NewTimer(wait)
+			}
+		}
+		// What bufio holds only drains once in the queue.
+		if buffered > 0 {
+			if err := nc.bw.Flush(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var expired <-chan time.Time
+		if timer != nil {
+			expired = timer.C
+		}
+		nc.mu.Unlock()
+		var err error
+		select {
+		case <-room:
+		case <-done:
+			err = ctx.Err()
+		case <-expired:
+			err = ErrOutboundFull
+		}
+		nc.mu.Lock()
+
+		switch {
+		case nc.isClosed():
+			return ErrConnectionClosed
+		case nc.isDrainingPubs():
+			return ErrConnectionDraining
+		case err == ErrOutboundFull:
+			nc.OutboundFull++
+			return err
+		case err != nil:
+			return err
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2021 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+// Pause stops the processing of messages by the subscription, which keeps
+// its interest, and its queue group membership, on the server.
+//
+// Messages keep arriving and are held up to the pending limits, past which
+// they are dropped as for a slow consumer, or per the MaxPending policy.
+// Asynchronous subscriptions stop invoking their handler, NextMsg and
+// Fetch wait for the subscription to be resumed. JetStream push consumers
+// hold their flow control response, so that the server stops sending once
+// the consumer reaches its flow control limits.
+//
+// Channel subscriptions cannot be paused. Pausing a paused subscription
+// does nothing.
+func (s *Subscription) Pause() error {
+	if s == nil {
+		return ErrBadSubscription
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrBadSubscription
+	}
+	if s.typ == ChanSubscription {
+		return ErrTypeSubscription
+	}
+	if !s.paused {
+		s.paused = true
+		s.resumed = make(chan struct{})
+	}
+	return nil
+}
+
+// Resume resumes the processing of messages by a subscription paused with
+// Pause, starting with the ones held while paused. Resuming a subscription
+// that is not paused does nothing.
+func (s *Subscription) Resume() error {
+	if s == nil {
+		return ErrBadSubscription
+	}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrBadSubscription
+	}
+	reply := s.resumeLocked()
+	nc := s.conn
+	s.mu.Unlock()
+
+	if reply != _EMPTY_ {
+		return nc.publish(reply, _EMPTY_, nil, nil)
+	}
+	return nil
+}
+
+// IsPaused returns true if the subscription is paused.
+func (s *Subscription) IsPaused() bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.paused
+}
+
+// resumeLocked resumes the subscription and returns the flow control
+// response held while it was paused, if any. Subscription lock is assumed
+// held.
+func (s *Subscription) resumeLocked() string {
+	if !s.paused {
+		return _EMPTY_
+	}
+	s.paused = false
+	close(s.resumed)
+	s.resumed = nil
+	if s.pHead != nil {
+		s.signal()
+	}
+	var reply string
+	if s.jsi != nil {
+		reply, s.jsi.fcReply = s.jsi.fcReply, _EMPTY_
+	}
+	return reply
+}
+
+// holdFlowControl keeps the flow control response of a paused JetStream
+// push consumer until it is resumed, and returns true if it did.
+func (s *Subscription) holdFlowControl(reply string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.paused {
+		return false
+	}
+	s.jsi.fcReply = reply
+	return true
+}
@@ -0,0 +1,229 @@
+// Copyright 2021 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import (
+	"sync/atomic"
+)
+
+// PendingLimitPolicy is what a connection does with a message that would
+// exceed the limits set with MaxPending.
+type PendingLimitPolicy int
+
+const (
+	// RejectNew drops the message, the way a subscription over its own
+	// pending limits does.
+	RejectNew PendingLimitPolicy = iota
+
+	// EvictLargest makes room for the message by dropping the oldest
+	// pending messages of the subscription with the most pending, in
+	// bytes if limited, or else in number of messages.
+	EvictLargest
+)
+
+// MaxPending is an Option to limit the number of messages, and of bytes,
+// pending delivery across all the subscriptions of the connection, on top
+// of the limits of each subscription. Channel subscriptions are not
+// accounted for. A zero or negative value means that the given metric is
+// not limited. A message over the limits is handled according to policy,
+// and ErrPendingLimit reported to the async error handler with the
+// subscription that lost messages.
+func MaxPending(msgs, bytes int, policy PendingLimitPolicy) Option {
+	return func(o *Options) error {
+		o.MaxPendingMsgs = msgs
+		o.MaxPendingBytes = bytes
+		o.PendingLimitPolicy = policy
+		return nil
+	}
+}
+
+// pendingLimited returns true if MaxPending limits are set.
+func (nc *Conn) pendingLimited() bool {
+	return nc.Opts.MaxPendingMsgs > 0 || nc.Opts.MaxPendingBytes > 0
+}
+
+// overPending returns true if a message of size bytes would exceed the
+// MaxPending limits.
+func (nc *Conn) overPending(size int) bool {
+	o := &nc.Opts
+	return (o.MaxPendingMsgs > 0 && atomic.LoadInt64(&nc.PendingMsgs)+1 > int64(o.MaxPendingMsgs)) ||
+		(o.MaxPendingBytes > 0 && atomic.LoadInt64(&nc.PendingBytes)+int64(size) > int64(o.MaxPendingBytes))
+}
+
+// overLimits returns true if a message of size bytes would exceed the
+// pending limits of the subscription. Subscription lock is assumed held.
+func (s *Subscription) overLimits(size int) bool {
+	return (s.pMsgsLimit > 0 && s.pMsgs+1 > s.pMsgsLimit) ||
+		(s.pBytesLimit > 0 && s.pBytes+size > s.pBytesLimit)
+}
+
+// addPending accounts for msgs messages, of size bytes, now pending, or
+// no longer pending if negative.
+func (nc *Conn) addPending(msgs, size int) {
+	atomic.AddInt64(&nc.PendingMsgs, int64(msgs))
+	atomic.AddInt64(&nc.PendingBytes, int64(size))
+}
+
+// pendingDone accounts for a message of size bytes that is no longer
+// pending. Once closed, what a subscription has pending was already
+// taken off the connection's. Subscription lock is assumed held.
+func (s *Subscription) pendingDone(size int) {
+	s.pMsgs--
+	s.pBytes -= size
+	if !s.closed && s.typ != ChanSubscription {
+		s.conn.addPending(-1, -size)
+	}
+}
+
+// pendingClosed takes what a subscription being closed has pending off
+// the connection's. Subscription lock is assumed held.
+func (s *Subscription) pendingClosed() {
+	if !s.closed && s.typ != ChanSubscription {
+		s.conn.addPending(-s.pMsgs, -s.pBytes)
+	}
+}
+
+// pendingRoom returns true if a message of size bytes fits within the
+// MaxPending limits, after evicting pending messages per EvictLargest.
+// It is only called by processMsg, so that pending counts can only go
+// down while it runs. No lock is to be held.
+func (nc *Conn) pendingRoom(size int) bool {
+	if !nc.overPending(size) {
+		return true
+	}
+	if nc.Opts.PendingLimitPolicy != EvictLargest {
+		return false
+	}
+	for nc.overPending(size) {
+		s := nc.largestPending()
+		if s == nil || !nc.evictPending(s, size) {
+			return false
+		}
+	}
+	return true
+}
+
+// largestPending returns the subscription with the most pending, among
+// those with messages that can be evicted, or nil if there is none.
+func (nc *Conn) largestPending() *Subscription {
+	byBytes := nc.Opts.MaxPendingBytes > 0
+	var largest *Subscription
+	var most int
+
+	nc.subsMu.RLock()
+	defer nc.subsMu.RUnlock()
+	for _, s := range nc.subs {
+		if s.typ == ChanSubscription {
+			continue
+		}
+		s.mu.Lock()
+		pending := s.pMsgs
+		if byBytes {
+			pending = s.pBytes
+		}
+		if pending > most && s.evictable() {
+			largest, most = s, pending
+		}
+		s.mu.Unlock()
+	}
+	return largest
+}
+
+// evictable returns true if the subscription has messages waiting to be
+// delivered. Subscription lock is assumed held.
+func (s *Subscription) evictable() bool {
+	if s.closed {
+		return false
+	}
+	if s.mch != nil {
+		return len(s.mch) > 0
+	}
+	for m := s.pHead; m != nil; m = m.next {
+		if m.barrier == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// evictPending drops the oldest pending messages of s until a message of
+// size bytes fits within the MaxPending limits, or there is none left,
+// and returns true if any was dropped.
+func (nc *Conn) evictPending(s *Subscription, size int) bool {
+	var evicted int
+	s.mu.Lock()
+	for nc.overPending(size) {
+		m := s.evictOldest()
+		if m == nil {
+			break
+		}
+		s.pendingDone(len(m.Data))
+		s.dropped++
+		evicted++
+		m.Release()
+	}
+	report := evicted > 0 && !s.sc
+	if evicted > 0 {
+		s.sc = true
+	}
+	s.mu.Unlock()
+
+	if evicted == 0 {
+		return false
+	}
+	atomic.AddUint64(&nc.PendingEvicted, uint64(evicted))
+	if report {
+		nc.mu.Lock()
+		nc.err = ErrPendingLimit
+		if nc.Opts.AsyncErrorCB != nil {
+			nc.ach.push(func() { nc.Opts.AsyncErrorCB(nc, s, ErrPendingLimit) })
+		}
+		nc.mu.Unlock()
+	}
+	return true
+}
+
+// evictOldest removes the oldest message waiting to be delivered, leaving
+// barriers in place, and returns it, or nil if there is none.
+// Subscription lock is assumed held.
+func (s *Subscription) evictOldest() *Msg {
+	if s.closed {
+		return nil
+	}
+	if s.mch != nil {
+		select {
+		case m := <-s.mch:
+			return m
+		default:
+			return nil
+		}
+	}
+	var prev *Msg
+	for m := s.pHead; m != nil; prev, m = m, m.next {
+		if m.barrier != nil {
+			continue
+		}
+		if prev == nil {
+			s.pHead = m.next
+		} else {
+			prev.next = m.next
+		}
+		if s.pTail == m {
+			s.pTail = prev
+		}
+		m.next = nil
+		return m
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+// Copyright 2021 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Subject wildcards and token separator.
+const (
+	pwc   = "*"
+	fwc   = ">"
+	btsep = '.'
+)
+
+// Operations reported by ErrPermissionDenied.
+const (
+	PermissionPublish   = "publish"
+	PermissionSubscribe = "subscribe"
+)
+
+// ErrPermissionDenied is the error returned by Publish and Subscribe when
+// the CheckPermissions option is set and the user JWT does not allow the
+// operation, and the error passed to the ErrorHandler when the server
+// reports a permissions violation.
+type ErrPermissionDenied struct {
+	// Operation is either PermissionPublish or PermissionSubscribe.
+	Operation string
+
+	// Subject is the subject that was denied.
+	Subject string
+
+	// Queue is the queue group of the subscription that was denied, if any.
+	Queue string
+
+	// msg is the error as sent by the server.
+	msg string
+}
+
+func (e *ErrPermissionDenied) Error() string {
+	if e.msg != _EMPTY_ {
+		return "nats: " + e.msg
+	}
+	// Same as the server would report it.
+	var sb strings.Builder
+	sb.WriteString("nats: Permissions Violation for ")
+	if e.Operation == PermissionSubscribe {
+		sb.WriteString("Subscription to ")
+	} else {
+		sb.WriteString("Publish to ")
+	}
+	sb.WriteString(strconv.Quote(e.Subject))
+	if e.Queue != _EMPTY_ {
+		fmt.Fprintf(&sb, " using queue %q", e.Queue)
+	}
+	return sb.String()
+}
+
+// parsePermissionsViolation returns the error for a permissions violation
+// sent by the server, such as:
+//
+//	Permissions Violation for Publish to "foo"
+//	Permissions Violation for Subscription to "foo" using queue "bar"
+//	Permissions Violation for Publish with Reply of "foo"
+func parsePermissionsViolation(msg string) *ErrPermissionDenied {
+	e := &ErrPermissionDenied{msg: msg}
+	lmsg := strings.ToLower(msg)
+	if strings.HasPrefix(lmsg, PERMISSIONS_ERR+" for subscription") {
+		e.Operation = PermissionSubscribe
+	} else {
+		e.Operation = PermissionPublish
+	}
+	quoted := func(s string) string {
+		if len(s) < 2 || s[0] != '"' {
+			return _EMPTY_
+		}
+		if i := strings.IndexByte(s[1:], '"'); i >= 0 {
+			return s[1 : i+1]
+		}
+		return _EMPTY_
+	}
+	if i := strings.IndexByte(msg, '"'); i >= 0 {
+		e.Subject = quoted(msg[i:])
+	}
+	if i := strings.Index(lmsg, " using queue "); i >= 0 {
+		e.Queue = quoted(msg[i+len(" using queue "):])
+	}
+	return e
+}
+
+// CheckPermissions is an Option to have Publish and Subscribe return an
+// ErrPermissionDenied error right away, instead of the server reporting
+// the violation asynchronously, when the user JWT does not allow them.
+// Only the permissions in the user JWT are known to the library: default
+// permissions of the account are not, so no check is done if the user
+// JWT has none. When the user is allowed to respond to requests, the
+// reply subjects of the requests it receives are not known either, so a
+// publish that the allow list does not cover is let through if it is to
+// an inbox, with the "_INBOX" prefix or the one of the connection, or to
+// a JetStream acknowledgement subject, as long as the deny list does not
+// cover it.
+func CheckPermissions() Option {
+	return func(o *Options) error {
+		o.CheckPermissions = true
+		return nil
+	}
+}
+
+// checkPublish returns an error if the user JWT does not allow to publish
+// to subj. Lock is assumed held.
+func (nc *Conn) checkPublish(subj string) error {
+	uc := nc.uclaims
+	if !nc.Opts.CheckPermissions || uc == nil || permitted(&uc.Pub, subj, _EMPTY_) {
+		return nil
+	}
+	if uc.AllowResponses && nc.maybeReply(subj) && !denied(&uc.Pub, subj, _EMPTY_) {
+		return nil
+	}
+	return &ErrPermissionDenied{Operation: PermissionPublish, Subject: subj}
+}
+
+// jsAckPrefix is the prefix of the reply subjects of JetStream messages.
+const jsAckPrefix = "$JS.ACK."
+
+// maybeReply returns true if subj could be the reply subject of a request
+// received by the connection. Lock is assumed held.
+func (nc *Conn) maybeReply(subj string) bool {
+	return strings.HasPrefix(subj, InboxPrefix) || strings.HasPrefix(subj, nc.inboxPrefix()) ||
+		strings.HasPrefix(subj, jsAckPrefix)
+}
+
+// checkSubscribe returns an error if the user JWT does not allow to
+// subscribe to subj. Lock is assumed held.
+func (nc *Conn) checkSubscribe(subj, queue string) error {
+	uc := nc.uclaims
+	if !nc.Opts.CheckPermissions || uc == nil {
+		return nil
+	}
+	if !permitted(&uc.Sub, subj, queue) {
+		return &ErrPermissionDenied{Operation: PermissionSubscribe, Subject: subj, Queue: queue}
+	}
+	return nil
+}
+
+// permitted returns true if subj, and queue for a subscription, is in the
+// allow list, if any, and not in the deny list. A subscription on a
+// wildcard subject is allowed if it is covered by an allowed subject, and
+// not denied by a deny entry that is narrower, as the server then only
+// filters the messages it delivers.
+func permitted(p *SubjectPermission, subj, queue string) bool {
+	if len(p.Allow) > 0 {
+		var allowed bool
+		for _, a := range p.Allow {
+			if permissionMatch(a, subj, queue) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+	return !denied(p, subj, queue)
+}
+
+// denied returns true if subj, and queue for a subscription, is in the
+// deny list.
+func denied(p *SubjectPermission, subj, queue string) bool {
+	for _, d := range p.Deny {
+		if permissionMatch(d, subj, queue) {
+			return true
+		}
+	}
+	return false
+}
+
+// permissionMatch returns true if the permission entry, a subject
+// optionally followed by a queue group for subscriptions, covers subj.
+func permissionMatch(entry, subj, queue string) bool {
+	psubj, pqueue := entry, _EMPTY_
+	if i := strings.IndexByte(entry, ' '); i >= 0 {
+		psubj, pqueue = entry[:i], strings.TrimSpace(entry[i+1:])
+	}
+	if pqueue != _EMPTY_ && (queue == _EMPTY_ || !subjectCovers(pqueue, queue)) {
+		return false
+	}
+	return subjectCovers(psubj, subj)
+}
+
+// subjectCovers returns true if every subject matching subj also
+// matches pattern.
+func subjectCovers(pattern, subj string) bool {
+	for {
+		pt, prest, pmore := nextToken(pattern)
+		st, srest, smore := nextToken(subj)
+		switch {
+		case pt == fwc:
+			return st != _EMPTY_
+		case st == fwc:
+			return false
+		case st == pwc:
+			if pt != pwc {
+				return false
+			}
+		case pt != pwc && pt != st:
+			return false
+		}
+		if !pmore || !smore {
+			return pmore == smore
+		}
+		pattern, subj = prest, srest
+	}
+}
+
+// nextToken splits the first token off subj.
+func nextToken(subj string) (string, string, bool) {
+	if i := strings.IndexByte(subj, btsep); i >= 0 {
+		return subj[:i], subj[i+1:], true
+	}
+	return subj, _EMPTY_, false
+}
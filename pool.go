@@ -0,0 +1,160 @@
+// Copyright 2021 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// Payload buffers larger than this are not kept by released messages.
+	maxPooledPayload = 64 * 1024
+
+	// Released payloads are filled with this byte with DebugMsgPool.
+	poisonByte = 0xdb
+)
+
+// msgPool holds the released messages of pooled subscriptions, along
+// with their payload buffer.
+var msgPool = &sync.Pool{}
+
+// SubscribePooled is like Subscribe, except that messages are taken from
+// a pool, with their payload, and the handler must call Msg.Release once
+// done with a message, and not use it afterwards. This saves the
+// allocation of a message, and of its payload, for most deliveries.
+// Messages that are not released are simply garbage collected.
+// See DebugMsgPool to detect messages used after they are released.
+func (nc *Conn) SubscribePooled(subj string, cb MsgHandler) (*Subscription, error) {
+	return nc.subscribePooled(subj, _EMPTY_, cb)
+}
+
+// QueueSubscribePooled is like QueueSubscribe, with messages taken from a
+// pool. See SubscribePooled for details.
+func (nc *Conn) QueueSubscribePooled(subj, queue string, cb MsgHandler) (*Subscription, error) {
+	return nc.subscribePooled(subj, queue, cb)
+}
+
+func (nc *Conn) subscribePooled(subj, queue string, cb MsgHandler) (*Subscription, error) {
+	if nc == nil {
+		return nil, ErrInvalidConnection
+	}
+	if cb == nil {
+		return nil, ErrBadSubscription
+	}
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	sub, err := nc.subscribeLocked(_EMPTY_, subj, queue, cb, nil, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	// Under the lock processMsg looks subscriptions up with. Should a
+	// message come before this, it is not pooled, which is harmless.
+	nc.subsMu.Lock()
+	sub.pooled = true
+	nc.subsMu.Unlock()
+	return sub, nil
+}
+
+// DebugMsgPool is an Option to detect the misuse of messages delivered
+// to pooled subscriptions: the payload of a released message is filled
+// with garbage, and the library panics if a message is released twice or
+// if a released message is modified. Released messages are not reused,
+// only their payload buffer is, so that a second Release is detected even
+// after the buffer was delivered again. Meant for tests, as it is slower.
+func DebugMsgPool() Option {
+	return func(o *Options) error {
+		o.DebugMsgPool = true
+		return nil
+	}
+}
+
+// getPooledMsg returns a message from the pool with room for a payload of
+// size bytes.
+func getPooledMsg(size int, debug bool) *Msg {
+	m, _ := msgPool.Get().(*Msg)
+	if m == nil {
+		m = &Msg{pooled: true}
+	}
+	m.reuse(size, debug)
+	return m
+}
+
+// reuse prepares a message taken from the pool for a payload of size
+// bytes, checking that it was not modified since released if poisoned.
+func (m *Msg) reuse(size int, debug bool) {
+	if m.poisoned {
+		for _, b := range m.buf[:cap(m.buf)] {
+			if b != poisonByte {
+				panic("nats: pooled message modified after Release")
+			}
+		}
+		m.poisoned = false
+	}
+	if cap(m.buf) < size {
+		m.buf = make([]byte, size)
+	}
+	m.debug = debug
+	atomic.StoreUint32(&m.released, 0)
+}
+
+// Release returns a message delivered to a subscription created with
+// SubscribePooled or QueueSubscribePooled to the pool, once the handler
+// is done with it, including with its Data and Header. It must be called
+// at most once, and the message not used afterwards: a second call is
+// ignored if the message was not delivered again since, and would release
+// a message in use otherwise. See DebugMsgPool to detect such calls.
+// It does nothing for other messages.
+func (m *Msg) Release() {
+	if m == nil || !m.pooled {
+		return
+	}
+	if pm := m.release(); pm != nil {
+		msgPool.Put(pm)
+	}
+}
+
+// release clears m and returns the message to put back in the pool, nil
+// if m was already released. With DebugMsgPool, m stays released for
+// good and its buffer is moved to a new message.
+func (m *Msg) release() *Msg {
+	if !atomic.CompareAndSwapUint32(&m.released, 0, 1) {
+		if m.debug {
+			panic("nats: pooled message released twice")
+		}
+		return nil
+	}
+	buf, debug := m.buf, m.debug
+	if cap(buf) > maxPooledPayload {
+		buf = nil
+	}
+	m.Subject, m.Reply, m.Header, m.hdr, m.Sub = _EMPTY_, _EMPTY_, nil, nil, nil
+	m.Data, m.next, m.barrier = nil, nil, nil
+	atomic.StoreUint32(&m.ackd, 0)
+	if debug {
+		buf = buf[:cap(buf)]
+		for i := range buf {
+			buf[i] = poisonByte
+		}
+		m.buf = nil
+		return &Msg{pooled: true, buf: buf, poisoned: true}
+	}
+	m.buf = buf
+	return m
+}
+
+// isReleased returns true if the message was released and not reused.
+func (m *Msg) isReleased() bool {
+	return m.pooled && atomic.LoadUint32(&m.released) == 1
+}
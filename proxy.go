@@ -0,0 +1,391 @@
+// Copyright 2021 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Proxy schemes supported by the ProxyURL option.
+const (
+	proxySchemeHTTP    = "http"
+	proxySchemeHTTPS   = "https"
+	proxySchemeSOCKS5  = "socks5"
+	proxySchemeSOCKS5H = "socks5h"
+)
+
+// SOCKS5 protocol constants, see RFC 1928 and RFC 1929.
+const (
+	socks5Version      = 0x05
+	socks5AuthNone     = 0x00
+	socks5AuthPassword = 0x02
+	socks5AuthNoAccept = 0xFF
+	socks5CmdConnect   = 0x01
+	socks5AddrIPv4     = 0x01
+	socks5AddrDomain   = 0x03
+	socks5AddrIPv6     = 0x04
+	socks5PassVersion  = 0x01
+)
+
+var (
+	ErrBadProxyURL   = errors.New("nats: invalid proxy url")
+	ErrProxyRejected = errors.New("nats: proxy rejected the connection")
+)
+
+// ProxyURL is an Option to tunnel connections to the servers through
+// a proxy. Supported schemes are http and https, for HTTP CONNECT, the
+// latter over TLS to the proxy, see ProxyTLSConfig, and socks5 or socks5h,
+// for SOCKS5. Credentials in the URL are used to authenticate with the
+// proxy. Host names are resolved by the proxy, except with socks5 for
+// which they are resolved locally and the proxy is given an IP address.
+// TLS, if any, still runs end-to-end with the server.
+func ProxyURL(proxyURL string) Option {
+	return func(o *Options) error {
+		if _, err := parseProxyURL(proxyURL); err != nil {
+			return err
+		}
+		o.ProxyURL = proxyURL
+		return nil
+	}
+}
+
+// ProxyTLSConfig is an Option to set the TLS configuration used to
+// connect to an https proxy, such as to trust its certificate authority.
+// The server name defaults to the host of the proxy URL.
+func ProxyTLSConfig(config *tls.Config) Option {
+	return func(o *Options) error {
+		o.ProxyTLSConfig = config
+		return nil
+	}
+}
+
+// ProxyFromEnvironment is an Option to pick the proxy from the
+// HTTPS_PROXY or ALL_PROXY environment variables (or their lowercase
+// versions) when ProxyURL is not set. Servers listed in NO_PROXY are
+// reached directly.
+func ProxyFromEnvironment() Option {
+	return func(o *Options) error {
+		o.ProxyFromEnvironment = true
+		return nil
+	}
+}
+
+// proxyConfig is the resolved proxy setup of a connection.
+type proxyConfig struct {
+	url     *url.URL
+	noProxy []string
+}
+
+func parseProxyURL(s string) (*url.URL, error) {
+	if !strings.Contains(s, "://") {
+		s = proxySchemeHTTP + "://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadProxyURL, err)
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	switch u.Scheme {
+	case proxySchemeHTTP, proxySchemeHTTPS, proxySchemeSOCKS5, proxySchemeSOCKS5H:
+	default:
+		return nil, fmt.Errorf("%w: unsupported scheme %q", ErrBadProxyURL, u.Scheme)
+	}
+	if u.Hostname() == _EMPTY_ {
+		return nil, fmt.Errorf("%w: missing host", ErrBadProxyURL)
+	}
+	if u.Port() == _EMPTY_ {
+		port := "1080"
+		switch u.Scheme {
+		case proxySchemeHTTP:
+			port = "80"
+		case proxySchemeHTTPS:
+			port = "443"
+		}
+		u.Host = net.JoinHostPort(u.Hostname(), port)
+	}
+	return u, nil
+}
+
+func getEnvAny(names ...string) string {
+	for _, n := range names {
+		if v := os.Getenv(n); v != _EMPTY_ {
+			return v
+		}
+	}
+	return _EMPTY_
+}
+
+// setupProxy resolves the proxy from the options and, if asked for,
+// from the environment.
+func (nc *Conn) setupProxy() error {
+	s := nc.Opts.ProxyURL
+	var noProxy string
+	if s == _EMPTY_ && nc.Opts.ProxyFromEnvironment {
+		s = getEnvAny("HTTPS_PROXY", "https_proxy", "ALL_PROXY", "all_proxy")
+		noProxy = getEnvAny("NO_PROXY", "no_proxy")
+	}
+	if s == _EMPTY_ {
+		return nil
+	}
+	u, err := parseProxyURL(s)
+	if err != nil {
+		return err
+	}
+	pc := &proxyConfig{url: u}
+	for _, h := range strings.Split(noProxy, ",") {
+		if h = strings.ToLower(strings.TrimSpace(h)); h != _EMPTY_ {
+			pc.noProxy = append(pc.noProxy, h)
+		}
+	}
+	nc.proxy = pc
+	return nil
+}
+
+// resolves returns true if the proxy resolves host names itself.
+func (pc *proxyConfig) resolves() bool {
+	return pc.url.Scheme != proxySchemeSOCKS5
+}
+
+// useFor returns true if connections to the given host should go
+// through the proxy.
+func (pc *proxyConfig) useFor(host string) bool {
+	if pc == nil {
+		return false
+	}
+	host = strings.ToLower(host)
+	for _, np := range pc.noProxy {
+		if np == "*" || np == host {
+			return false
+		}
+		// Both ".example.com" and "example.com" match sub domains.
+		if strings.HasSuffix(host, "."+strings.TrimPrefix(np, ".")) {
+			return false
+		}
+	}
+	return true
+}
+
+// proxyDialer tunnels connections through a proxy, reaching the proxy
+// itself with the forward dialer.
+type proxyDialer struct {
+	url       *url.URL
+	forward   CustomDialer
+	timeout   time.Duration
+	tlsConfig *tls.Config
+}
+
+// Dial implements CustomDialer.
+func (d *proxyDialer) Dial(network, address string) (net.Conn, error) {
+	conn, err := d.forward.Dial(network, d.url.Host)
+	if err != nil {
+		return nil, err
+	}
+	if d.timeout > 0 {
+		conn.SetDeadline(time.Now().Add(d.timeout))
+	}
+	tunnel := conn
+	switch d.url.Scheme {
+	case proxySchemeHTTPS:
+		if conn, err = d.tlsConnect(conn); err == nil {
+			tunnel, err = d.httpConnect(conn, address)
+		}
+	case proxySchemeHTTP:
+		tunnel, err = d.httpConnect(conn, address)
+	default:
+		err = d.socks5Connect(conn, address)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return tunnel, nil
+}
+
+// tlsConnect runs the TLS handshake with an https proxy over conn.
+func (d *proxyDialer) tlsConnect(conn net.Conn) (net.Conn, error) {
+	var config *tls.Config
+	if d.tlsConfig != nil {
+		config = d.tlsConfig.Clone()
+	} else {
+		config = &tls.Config{}
+	}
+	if config.ServerName == _EMPTY_ {
+		config.ServerName = d.url.Hostname()
+	}
+	tconn := tls.Client(conn, config)
+	if err := tconn.Handshake(); err != nil {
+		return conn, err
+	}
+	return tconn, nil
+}
+
+func (d *proxyDialer) httpConnect(conn net.Conn, address string) (net.Conn, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+	if u := d.url.User; u != nil {
+		pass, _ := u.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(u.Username() + ":" + pass))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s", ErrProxyRejected, resp.Status)
+	}
+	// The server sends INFO as soon as the tunnel is up, so it may
+	// already be sitting in our reader.
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+func (d *proxyDialer) socks5Connect(conn net.Conn, address string) error {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return err
+	}
+
+	method := byte(socks5AuthNone)
+	if d.url.User != nil {
+		method = socks5AuthPassword
+	}
+	if _, err := conn.Write([]byte{socks5Version, 1, method}); err != nil {
+		return err
+	}
+	var resp [2]byte
+	if _, err := io.ReadFull(conn, resp[:]); err != nil {
+		return err
+	}
+	if resp[0] != socks5Version || resp[1] == socks5AuthNoAccept || resp[1] != method {
+		return fmt.Errorf("%w: no acceptable authentication method", ErrProxyRejected)
+	}
+	if method == socks5AuthPassword {
+		user := d.url.User.Username()
+		pass, _ := d.url.User.Password()
+		if len(user) > 255 || len(pass) > 255 {
+			return fmt.Errorf("%w: proxy credentials too long", ErrBadProxyURL)
+		}
+		b := []byte{socks5PassVersion, byte(len(user))}
+		b = append(b, user...)
+		b = append(b, byte(len(pass)))
+		b = append(b, pass...)
+		if _, err := conn.Write(b); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, resp[:]); err != nil {
+			return err
+		}
+		if resp[1] != 0 {
+			return fmt.Errorf("%w: authentication failed", ErrProxyRejected)
+		}
+	}
+
+	// With socks5, as opposed to socks5h, the proxy is given an address.
+	ip := net.ParseIP(host)
+	if ip == nil && d.url.Scheme == proxySchemeSOCKS5 {
+		addrs, err := net.LookupIP(host)
+		if err != nil {
+			return err
+		}
+		ip = addrs[0]
+	}
+	b := []byte{socks5Version, socks5CmdConnect, 0}
+	if ip == nil {
+		if len(host) > 255 {
+			return fmt.Errorf("%w: host name too long", ErrProxyRejected)
+		}
+		b = append(b, socks5AddrDomain, byte(len(host)))
+		b = append(b, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		b = append(b, socks5AddrIPv4)
+		b = append(b, ip4...)
+	} else {
+		b = append(b, socks5AddrIPv6)
+		b = append(b, ip...)
+	}
+	b = append(b, byte(port>>8), byte(port))
+	if _, err := conn.Write(b); err != nil {
+		return err
+	}
+
+	// Reply is version, status, reserved, then the bound address.
+	var hdr [4]byte
+	if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+		return err
+	}
+	if hdr[1] != 0 {
+		return fmt.Errorf("%w: socks5 error code %d", ErrProxyRejected, hdr[1])
+	}
+	var skip int
+	switch hdr[3] {
+	case socks5AddrIPv4:
+		skip = net.IPv4len
+	case socks5AddrIPv6:
+		skip = net.IPv6len
+	case socks5AddrDomain:
+		var l [1]byte
+		if _, err := io.ReadFull(conn, l[:]); err != nil {
+			return err
+		}
+		skip = int(l[0])
+	default:
+		return fmt.Errorf("%w: bad socks5 reply", ErrProxyRejected)
+	}
+	// The bound address and port are of no use to us.
+	_, err = io.ReadFull(conn, make([]byte, skip+2))
+	return err
+}
+
+// bufferedConn is a net.Conn that first returns what was already read
+// into r.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	if c.r.Buffered() > 0 {
+		return c.r.Read(b)
+	}
+	return c.Conn.Read(b)
+}
@@ -0,0 +1,167 @@
+// Copyright 2021 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"time"
+
+	"github.com/nats-io/nuid"
+)
+
+// ForceReconnect closes the connection to the current server and goes
+// through the regular reconnect process, as if the connection had been
+// lost. Messages published while reconnecting are buffered, and the
+// disconnect and reconnect callbacks are invoked, as usual. The server
+// we were connected to is tried last. ErrConnectionReconnecting is
+// returned while reconnecting, or while moving to another connection
+// after a lame duck mode notification or a call to Reauthenticate.
+func (nc *Conn) ForceReconnect() error {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	return nc.forceReconnect(nil)
+}
+
+// forceReconnect starts the reconnect process, trying target first if
+// not nil. A ReconnectStrategy, if set, still has the final say.
+// Lock is assumed held.
+func (nc *Conn) forceReconnect(target *srv) error {
+	switch {
+	case nc.isClosed():
+		return ErrConnectionClosed
+	case nc.isDraining():
+		return ErrConnectionDraining
+	case nc.status != CONNECTED:
+		return ErrConnectionReconnecting
+	case nc.mig != nil:
+		// Moving to another connection already, see migrate.
+		return ErrConnectionReconnecting
+	case !nc.Opts.AllowReconnect:
+		return ErrReconnectNotAllowed
+	}
+	if target != nil {
+		// The current server is moved to the end of the pool and the
+		// first one is tried next, see selectNextServer.
+		for i, s := range nc.srvPool {
+			if s == target {
+				copy(nc.srvPool[1:i+1], nc.srvPool[:i])
+				nc.srvPool[0] = target
+				break
+			}
+		}
+	}
+	nc.startReconnect(nil)
+	return nil
+}
+
+// startRebalancer starts the periodic rebalance check, if enabled.
+func (nc *Conn) startRebalancer() {
+	if nc.Opts.RebalanceInterval <= 0 {
+		return
+	}
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	if nc.isClosed() {
+		return
+	}
+	nc.rbid = nuid.Next()
+	nc.rbtmr = time.AfterFunc(nc.rebalanceDelay(), nc.rebalance)
+}
+
+func (nc *Conn) rebalanceDelay() time.Duration {
+	d := nc.Opts.RebalanceInterval
+	if j := nc.Opts.RebalanceJitter; j > 0 {
+		d += time.Duration(rand.Int63n(int64(j)))
+	}
+	return d
+}
+
+// rebalance reconnects to the server assigned to this connection if
+// connected to another one.
+func (nc *Conn) rebalance() {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	// Stopped on close.
+	if nc.rbtmr == nil {
+		return
+	}
+	if nc.status == CONNECTED && nc.mig == nil {
+		if target := nc.rebalanceTarget(); target != nil {
+			nc.forceReconnect(target)
+		}
+	}
+	nc.rbtmr.Reset(nc.rebalanceDelay())
+}
+
+// rebalanceTarget returns the server of the pool this connection should
+// be connected to, or nil if it already is or if this can't be told.
+// Servers are assigned by rendezvous hashing, so that connections are
+// spread evenly and only the ones assigned to a server that joins or
+// leaves the cluster move. Lock is assumed held.
+func (nc *Conn) rebalanceTarget() *srv {
+	urls := nc.info.ConnectURLs
+	if len(urls) < 2 || nc.current == nil || nc.Opts.InProcessServer != nil {
+		return nil
+	}
+	cur := nc.current.url.Host
+	home := rendezvousPick(nc.rbid, urls)
+	if home == cur {
+		return nil
+	}
+	// We can only tell where we are if connected through one of the
+	// gossiped URLs, not, say, through a load balancer.
+	var found bool
+	for _, u := range urls {
+		if u == cur {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil
+	}
+	for _, s := range nc.srvPool {
+		if s.url.Host == home && !s.removed {
+			return s
+		}
+	}
+	return nil
+}
+
+// rendezvousPick returns the url with the highest weight for id.
+func rendezvousPick(id string, urls []string) string {
+	var (
+		best   string
+		bestW  uint64
+		picked bool
+	)
+	for _, u := range urls {
+		h := fnv.New64a()
+		h.Write([]byte(id))
+		h.Write([]byte(u))
+		// Mix the bits, the URLs often only differ by their last
+		// characters.
+		w := h.Sum64()
+		w ^= w >> 33
+		w *= 0xff51afd7ed558ccd
+		w ^= w >> 33
+		w *= 0xc4ceb9fe1a85ec53
+		w ^= w >> 33
+		if !picked || w > bestW {
+			best, bestW, picked = u, w, true
+		}
+	}
+	return best
+}
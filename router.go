@@ -0,0 +1,242 @@
+// Copyright 2021 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Params holds the values of the named parameters of a route, by name.
+type Params map[string]string
+
+// RouteHandler is a handler of the messages of a route, given the values
+// of the route parameters.
+type RouteHandler func(m *Msg, params Params)
+
+// Middleware wraps a RouteHandler, for instance for logging or to recover
+// from panics.
+type Middleware func(next RouteHandler) RouteHandler
+
+// Router dispatches messages to handlers by subject pattern, subscribing
+// to the least specific subject that covers each pattern.
+//
+// Patterns are subjects whose tokens can be named parameters, as in
+// "orders.{region}.{id}.created", subscribed to as "orders.*.*.created",
+// with the region and id tokens of each message passed to the handler.
+// The last token can also be a named tail parameter, as in "logs.{path>}",
+// subscribed to as "logs.>", the value being the remaining tokens. The
+// wildcards "*" and ">" are allowed too, without a value.
+//
+// Routes that could both match a subject are rejected with
+// ErrRouteConflict, so that a message is handled by at most one route.
+type Router struct {
+	// Shared by the groups of the router.
+	table *routes
+
+	prefix []string
+	mw     []Middleware
+}
+
+// routes are the routes of a router and of its groups.
+type routes struct {
+	nc   *Conn
+	mu   sync.Mutex
+	list []*route
+}
+
+// route is a registered pattern.
+type route struct {
+	pattern string
+	tokens  []string
+	// Name of the parameter of each token, if any.
+	params []string
+	sub    *Subscription
+}
+
+// NewRouter returns a router of messages received by nc.
+func NewRouter(nc *Conn) *Router {
+	return &Router{table: &routes{nc: nc}}
+}
+
+// Use adds middleware to the router, which wraps the handlers of the
+// routes registered afterwards, the first added being the outermost.
+func (r *Router) Use(mw ...Middleware) {
+	r.mw = append(r.mw, mw...)
+}
+
+// Group returns a router sharing the routes, subscriptions and middleware
+// of r, whose patterns are prefixed by prefix, which can have parameters.
+// Middleware given or added to the group only applies to its routes.
+func (r *Router) Group(prefix string, mw ...Middleware) *Router {
+	g := &Router{table: r.table}
+	g.prefix = append(append(g.prefix, r.prefix...), strings.Split(prefix, ".")...)
+	g.mw = append(append(g.mw, r.mw...), mw...)
+	return g
+}
+
+// Handle registers h for the messages matching pattern.
+func (r *Router) Handle(pattern string, h RouteHandler) error {
+	return r.handle(pattern, _EMPTY_, h)
+}
+
+// QueueHandle registers h for the messages matching pattern, subscribing
+// as a member of the queue group.
+func (r *Router) QueueHandle(pattern, queue string, h RouteHandler) error {
+	return r.handle(pattern, queue, h)
+}
+
+func (r *Router) handle(pattern, queue string, h RouteHandler) error {
+	if h == nil {
+		return ErrBadSubscription
+	}
+	tokens := strings.Split(pattern, ".")
+	if len(r.prefix) > 0 {
+		tokens = append(append([]string{}, r.prefix...), tokens...)
+	}
+	rt, err := parseRoute(tokens)
+	if err != nil {
+		return err
+	}
+	for i := len(r.mw) - 1; i >= 0; i-- {
+		h = r.mw[i](h)
+	}
+
+	r.table.mu.Lock()
+	defer r.table.mu.Unlock()
+	for _, o := range r.table.list {
+		if rt.overlaps(o) {
+			return fmt.Errorf("%w: %q and %q", ErrRouteConflict, rt.pattern, o.pattern)
+		}
+	}
+	rt.sub, err = r.table.nc.QueueSubscribe(rt.subject(), queue, func(m *Msg) {
+		h(m, rt.extract(m.Subject))
+	})
+	if err != nil {
+		return err
+	}
+	r.table.list = append(r.table.list, rt)
+	return nil
+}
+
+// Unsubscribe removes the subscriptions of all the routes of the router
+// and of its groups.
+func (r *Router) Unsubscribe() error {
+	return r.close(false)
+}
+
+// Drain drains the subscriptions of all the routes of the router and of
+// its groups. See Subscription.Drain.
+func (r *Router) Drain() error {
+	return r.close(true)
+}
+
+func (r *Router) close(drain bool) error {
+	r.table.mu.Lock()
+	list := r.table.list
+	r.table.list = nil
+	r.table.mu.Unlock()
+
+	var first error
+	for _, rt := range list {
+		var err error
+		if drain {
+			err = rt.sub.Drain()
+		} else {
+			err = rt.sub.Unsubscribe()
+		}
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// parseRoute parses the tokens of a pattern.
+func parseRoute(tokens []string) (*route, error) {
+	rt := &route{
+		pattern: strings.Join(tokens, "."),
+		tokens:  tokens,
+		params:  make([]string, len(tokens)),
+	}
+	seen := make(map[string]bool)
+	for i, t := range tokens {
+		last := i == len(tokens)-1
+		switch {
+		case t == _EMPTY_ || strings.ContainsAny(t, " \t\r\n"):
+			return nil, fmt.Errorf("%w: %q", ErrBadPattern, rt.pattern)
+		case t == "*":
+		case t == ">":
+			if !last {
+				return nil, fmt.Errorf("%w: %q", ErrBadPattern, rt.pattern)
+			}
+		case t[0] == '{' && t[len(t)-1] == '}':
+			name := t[1 : len(t)-1]
+			if strings.HasSuffix(name, ">") {
+				if !last {
+					return nil, fmt.Errorf("%w: %q", ErrBadPattern, rt.pattern)
+				}
+				name = strings.TrimSuffix(name, ">")
+				tokens[i] = ">"
+			} else {
+				tokens[i] = "*"
+			}
+			if name == _EMPTY_ || strings.ContainsAny(name, "{}*>") || seen[name] {
+				return nil, fmt.Errorf("%w: %q", ErrBadPattern, rt.pattern)
+			}
+			seen[name] = true
+			rt.params[i] = name
+		case strings.ContainsAny(t, "{}*>"):
+			return nil, fmt.Errorf("%w: %q", ErrBadPattern, rt.pattern)
+		}
+	}
+	return rt, nil
+}
+
+// subject returns the subject to subscribe to for the route.
+func (rt *route) subject() string {
+	return strings.Join(rt.tokens, ".")
+}
+
+// overlaps returns true if a subject can match both routes.
+func (rt *route) overlaps(o *route) bool {
+	a, b := rt.tokens, o.tokens
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] == ">" || b[i] == ">" {
+			return true
+		}
+		if a[i] != b[i] && a[i] != "*" && b[i] != "*" {
+			return false
+		}
+	}
+	return len(a) == len(b)
+}
+
+// extract returns the values of the route parameters in subject.
+func (rt *route) extract(subject string) Params {
+	params := make(Params)
+	tokens := strings.Split(subject, ".")
+	for i, name := range rt.params {
+		if name == _EMPTY_ || i >= len(tokens) {
+			continue
+		}
+		if rt.tokens[i] == ">" {
+			params[name] = strings.Join(tokens[i:], ".")
+		} else {
+			params[name] = tokens[i]
+		}
+	}
+	return params
+}
@@ -0,0 +1,340 @@
+// Copyright 2021 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import (
+	"math/rand"
+	"time"
+)
+
+// ServerStats is the history recorded for a server of the pool.
+type ServerStats struct {
+	URL        string
+	IsImplicit bool
+	DidConnect bool
+
+	// ConsecutiveFailures is the number of attempts made since
+	// the last successful connection to this server.
+	ConsecutiveFailures int
+
+	// AuthFailures is the total number of authorization errors
+	// returned by this server.
+	AuthFailures int
+
+	// LastError is the error of the last failed attempt, or the
+	// error that caused the last disconnect.
+	LastError error
+
+	LastAttempt    time.Time
+	LastDisconnect time.Time
+
+	// HandshakeTime is how long the last successful connect took,
+	// from dialing to receiving the first PONG.
+	HandshakeTime time.Duration
+
+	// RTT is the last measured round trip time, zero if unknown.
+	RTT time.Duration
+}
+
+// lastActivity returns the last time the server was attempted or lost.
+func (s *ServerStats) lastActivity() time.Time {
+	if s.LastDisconnect.After(s.LastAttempt) {
+		return s.LastDisconnect
+	}
+	return s.LastAttempt
+}
+
+// ReconnectState is given to a ReconnectStrategy to pick a server.
+type ReconnectState struct {
+	// Attempts is the number of attempts made since disconnected.
+	Attempts int
+	// LastDelay is the delay returned by the previous call.
+	LastDelay time.Duration
+	// Servers holds the servers of the pool, in pool order.
+	Servers []ServerStats
+}
+
+// ReconnectStrategy decides which server to try next while reconnecting
+// and how long to wait before doing so. It replaces the default rotation
+// through the pool and the ReconnectWait, ReconnectJitter and
+// CustomReconnectDelayCB settings. MaxReconnect is still honored by
+// removing servers from the pool before the strategy is consulted.
+type ReconnectStrategy interface {
+	// Next returns the index in state.Servers of the server to try and
+	// the time to wait before trying it. A negative index stops the
+	// reconnect process and closes the connection.
+	Next(state *ReconnectState) (int, time.Duration)
+}
+
+// SetReconnectStrategy is an Option to set the ReconnectStrategy used
+// while reconnecting.
+func SetReconnectStrategy(strategy ReconnectStrategy) Option {
+	return func(o *Options) error {
+		o.ReconnectStrategy = strategy
+		return nil
+	}
+}
+
+// leastRecentlyUsed returns the index of the server that was least
+// recently attempted or lost, preferring servers with fewer failures.
+func leastRecentlyUsed(servers []ServerStats) int {
+	best := -1
+	for i := range servers {
+		if best < 0 {
+			best = i
+			continue
+		}
+		s, b := &servers[i], &servers[best]
+		if s.ConsecutiveFailures != b.ConsecutiveFailures {
+			if s.ConsecutiveFailures < b.ConsecutiveFailures {
+				best = i
+			}
+			continue
+		}
+		if s.lastActivity().Before(b.lastActivity()) {
+			best = i
+		}
+	}
+	return best
+}
+
+// Defaults used by ExponentialBackoff.
+const (
+	DefaultBackoffBase = 100 * time.Millisecond
+	DefaultBackoffMax  = 30 * time.Second
+)
+
+// ExponentialBackoff is a ReconnectStrategy trying servers in turn and
+// waiting between attempts with exponential backoff and decorrelated
+// jitter, that is a random delay between Base and three times the
+// previous delay, capped at Max.
+type ExponentialBackoff struct {
+	Base time.Duration
+	Max  time.Duration
+}
+
+// Next implements ReconnectStrategy.
+func (b *ExponentialBackoff) Next(state *ReconnectState) (int, time.Duration) {
+	idx := leastRecentlyUsed(state.Servers)
+	// The first attempt is made right away.
+	if state.Attempts == 0 {
+		return idx, 0
+	}
+	return idx, b.delay(state.LastDelay)
+}
+
+func (b *ExponentialBackoff) delay(prev time.Duration) time.Duration {
+	base, max := b.Base, b.Max
+	if base <= 0 {
+		base = DefaultBackoffBase
+	}
+	if max <= 0 {
+		max = DefaultBackoffMax
+	}
+	if prev < base {
+		prev = base
+	}
+	d := base + time.Duration(rand.Int63n(int64(3*prev-base)+1))
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+// LowestRTTFirst is a ReconnectStrategy trying the servers with the
+// fewest failures first and, among those, the one with the lowest known
+// round trip time. Once every server failed as many times as the others,
+// it waits Wait plus a random Jitter before starting over.
+type LowestRTTFirst struct {
+	Wait   time.Duration
+	Jitter time.Duration
+}
+
+// Next implements ReconnectStrategy.
+func (l *LowestRTTFirst) Next(state *ReconnectState) (int, time.Duration) {
+	servers := state.Servers
+	best := -1
+	for i := range servers {
+		if best < 0 || rttLess(&servers[i], &servers[best]) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return best, 0
+	}
+	// Wait only when starting a new pass over the pool.
+	failures := servers[best].ConsecutiveFailures
+	if failures == 0 {
+		return best, 0
+	}
+	for i := range servers {
+		if servers[i].ConsecutiveFailures != failures {
+			return best, 0
+		}
+	}
+	wait := l.Wait
+	if wait <= 0 {
+		wait = DefaultReconnectWait
+	}
+	if l.Jitter > 0 {
+		wait += time.Duration(rand.Int63n(int64(l.Jitter)))
+	}
+	return best, wait
+}
+
+// rttLess orders servers by failures, then known RTT, then activity.
+func rttLess(a, b *ServerStats) bool {
+	if a.ConsecutiveFailures != b.ConsecutiveFailures {
+		return a.ConsecutiveFailures < b.ConsecutiveFailures
+	}
+	if a.RTT != b.RTT {
+		// An unknown RTT comes last.
+		if a.RTT == 0 || b.RTT == 0 {
+			return b.RTT == 0
+		}
+		return a.RTT < b.RTT
+	}
+	return a.lastActivity().Before(b.lastActivity())
+}
+
+// Defaults used by CircuitBreaker.
+const (
+	DefaultBreakerThreshold = 3
+	DefaultBreakerCooldown  = 30 * time.Second
+)
+
+// CircuitBreaker is a ReconnectStrategy parking servers that failed
+// Threshold times in a row for Cooldown, and letting Strategy pick among
+// the others. When every server is parked, the one coming out of the
+// cooldown first is tried once it does. Strategy defaults to an
+// ExponentialBackoff.
+type CircuitBreaker struct {
+	Strategy  ReconnectStrategy
+	Threshold int
+	Cooldown  time.Duration
+}
+
+// Next implements ReconnectStrategy.
+func (c *CircuitBreaker) Next(state *ReconnectState) (int, time.Duration) {
+	threshold, cooldown := c.Threshold, c.Cooldown
+	if threshold <= 0 {
+		threshold = DefaultBreakerThreshold
+	}
+	if cooldown <= 0 {
+		cooldown = DefaultBreakerCooldown
+	}
+	now := time.Now()
+	closed := make([]int, 0, len(state.Servers))
+	first := -1
+	var firstReopen time.Time
+	for i := range state.Servers {
+		s := &state.Servers[i]
+		if s.ConsecutiveFailures >= threshold {
+			if reopen := s.LastAttempt.Add(cooldown); reopen.After(now) {
+				if first < 0 || reopen.Before(firstReopen) {
+					first, firstReopen = i, reopen
+				}
+				continue
+			}
+		}
+		closed = append(closed, i)
+	}
+	if len(closed) == 0 {
+		if first < 0 {
+			return -1, 0
+		}
+		return first, firstReopen.Sub(now)
+	}
+
+	inner := c.Strategy
+	if inner == nil {
+		inner = &ExponentialBackoff{}
+	}
+	sub := &ReconnectState{
+		Attempts:  state.Attempts,
+		LastDelay: state.LastDelay,
+		Servers:   make([]ServerStats, len(closed)),
+	}
+	for i, idx := range closed {
+		sub.Servers[i] = state.Servers[idx]
+	}
+	idx, d := inner.Next(sub)
+	if idx < 0 || idx >= len(closed) {
+		return -1, 0
+	}
+	return closed[idx], d
+}
+
+// stats returns the recorded history of the server.
+func (s *srv) stats() ServerStats {
+	return ServerStats{
+		URL:                 redactURL(s.url),
+		IsImplicit:          s.isImplicit,
+		DidConnect:          s.didConnect,
+		ConsecutiveFailures: s.reconnects,
+		AuthFailures:        s.authFailures,
+		LastError:           s.lastFailure,
+		LastAttempt:         s.lastAttempt,
+		LastDisconnect:      s.lastDisconnect,
+		HandshakeTime:       s.handshake,
+		RTT:                 s.rtt,
+	}
+}
+
+// ServerStats returns the history recorded for the servers of the pool.
+func (nc *Conn) ServerStats() []ServerStats {
+	nc.mu.RLock()
+	defer nc.mu.RUnlock()
+	stats := make([]ServerStats, 0, len(nc.srvPool))
+	for _, s := range nc.srvPool {
+		stats = append(stats, s.stats())
+	}
+	return stats
+}
+
+// selectByStrategy asks the strategy for the next server to try and
+// makes it the current one. Lock is assumed held.
+func (nc *Conn) selectByStrategy(rs ReconnectStrategy, state *ReconnectState) (*srv, time.Duration, error) {
+	// Drop the servers that used all their attempts, as well as the
+	// current one if it was removed from the configured servers.
+	maxReconnect := nc.Opts.MaxReconnect
+	sp := nc.srvPool[:0]
+	for _, s := range nc.srvPool {
+		if s.removed || (maxReconnect >= 0 && s.reconnects >= maxReconnect) {
+			continue
+		}
+		sp = append(sp, s)
+	}
+	nc.srvPool = sp
+	if len(sp) == 0 {
+		nc.current = nil
+		return nil, 0, ErrNoServers
+	}
+
+	state.Servers = state.Servers[:0]
+	for _, s := range sp {
+		state.Servers = append(state.Servers, s.stats())
+	}
+	idx, d := rs.Next(state)
+	if idx < 0 || idx >= len(sp) {
+		return nil, 0, ErrNoServers
+	}
+	if d < 0 {
+		d = 0
+	}
+	state.Attempts++
+	state.LastDelay = d
+	nc.current = sp[idx]
+	return nc.current, d, nil
+}
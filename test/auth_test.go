@@ -14,6 +14,7 @@
 package test
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 	"sync/atomic"
@@ -350,6 +351,15 @@ func TestPermViolation(t *testing.T) {
 			} else if expectedErr == "subscribe" && !strings.Contains(e.Error(), "Foo") {
 				t.Fatalf("Subject Foo not found in error: %v", e)
 			}
+			var perr *nats.ErrPermissionDenied
+			if !errors.As(e, &perr) {
+				t.Fatalf("Expected a permission denied error, got %T", e)
+			}
+			if expectedErr == "publish" && (perr.Operation != nats.PermissionPublish || perr.Subject != "Bar") {
+				t.Fatalf("Unexpected error: %+v", perr)
+			} else if expectedErr == "subscription" && (perr.Operation != nats.PermissionSubscribe || perr.Subject != "Foo") {
+				t.Fatalf("Unexpected error: %+v", perr)
+			}
 		case <-time.After(2 * time.Second):
 			t.Fatalf("Did not get the permission error")
 		}
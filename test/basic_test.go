@@ -20,6 +20,7 @@ import (
 	"math"
 	"regexp"
 	"runtime"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -207,6 +208,113 @@ func TestSimplePublishNoData(t *testing.T) {
 	}
 }
 
+func TestPublishLargePayloads(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	sub, err := nc.SubscribeSync("foo")
+	if err != nil {
+		t.Fatal("Failed to subscribe: ", err)
+	}
+	// Large payloads are written along with what is buffered, small ones
+	// are buffered, make sure ordering is preserved.
+	var sent [][]byte
+	for i, size := range []int{10, 64 * 1024, 20, 30, 8 * 1024, 512 * 1024, 8*1024 - 1, 40} {
+		data := bytes.Repeat([]byte{byte('a' + i)}, size)
+		sent = append(sent, data)
+		if i%2 == 0 {
+			err = nc.Publish("foo", data)
+		} else {
+			m := nats.NewMsg("foo")
+			m.Header.Set("Index", fmt.Sprint(i))
+			m.Data = data
+			err = nc.PublishMsg(m)
+		}
+		if err != nil {
+			t.Fatalf("Error on publish: %v", err)
+		}
+		// The publisher may reuse its buffer once published.
+		data[0] = 'X'
+	}
+	for i, data := range sent {
+		data[0] = byte('a' + i)
+		msg, err := sub.NextMsg(time.Second)
+		if err != nil {
+			t.Fatalf("Error on message %d: %v", i, err)
+		}
+		if !bytes.Equal(msg.Data, data) {
+			t.Fatalf("Unexpected payload for message %d, got %d bytes", i, len(msg.Data))
+		}
+		if i%2 == 1 && msg.Header.Get("Index") != fmt.Sprint(i) {
+			t.Fatalf("Unexpected header for message %d: %v", i, msg.Header)
+		}
+	}
+}
+
+func TestPublishBatch(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	sub, err := nc.SubscribeSync("foo.*")
+	if err != nil {
+		t.Fatal("Failed to subscribe: ", err)
+	}
+	var msgs []*nats.Msg
+	for i := 0; i < 100; i++ {
+		m := nats.NewMsg(fmt.Sprintf("foo.%d", i))
+		m.Data = []byte(fmt.Sprintf("msg %d", i))
+		if i%10 == 0 {
+			m.Header.Set("Index", fmt.Sprint(i))
+			m.Data = bytes.Repeat(m.Data, 2000)
+		}
+		msgs = append(msgs, m)
+	}
+	if err := nc.PublishBatch(msgs); err != nil {
+		t.Fatalf("Error on publish: %v", err)
+	}
+	for i, m := range msgs {
+		msg, err := sub.NextMsg(time.Second)
+		if err != nil {
+			t.Fatalf("Error on message %d: %v", i, err)
+		}
+		if msg.Subject != m.Subject || !bytes.Equal(msg.Data, m.Data) || msg.Header.Get("Index") != m.Header.Get("Index") {
+			t.Fatalf("Unexpected message %d: %+v", i, msg)
+		}
+	}
+	if stats := nc.Stats(); stats.OutMsgs != 100 {
+		t.Fatalf("Expected 100 messages out, got %d", stats.OutMsgs)
+	}
+
+	// Nothing is sent if a message is invalid.
+	for _, bad := range []struct {
+		m   *nats.Msg
+		err error
+	}{
+		{nil, nats.ErrInvalidMsg},
+		{&nats.Msg{}, nats.ErrBadSubject},
+		{&nats.Msg{Subject: "foo.bar", Data: make([]byte, 2*1024*1024)}, nats.ErrMaxPayload},
+	} {
+		if err := nc.PublishBatch(append(msgs[:1:1], bad.m)); err != bad.err {
+			t.Fatalf("Expected %v, got %v", bad.err, err)
+		}
+	}
+	if err := nc.Flush(); err != nil {
+		t.Fatalf("Error on flush: %v", err)
+	}
+	if msg, err := sub.NextMsg(100 * time.Millisecond); err == nil {
+		t.Fatalf("Unexpected message: %+v", msg)
+	}
+
+	nc.Close()
+	if err := nc.PublishBatch(msgs); err != nats.ErrConnectionClosed {
+		t.Fatalf("Expected %v, got %v", nats.ErrConnectionClosed, err)
+	}
+}
+
 func TestPublishDoesNotFailOnSlowConsumer(t *testing.T) {
 	s := RunDefaultServer()
 	defer s.Shutdown()
@@ -703,6 +811,87 @@ func TestRequestNoBody(t *testing.T) {
 	}
 }
 
+func TestCustomInboxPrefix(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	if _, err := nats.Connect(nats.DefaultURL, nats.CustomInboxPrefix("_SVC.*")); err != nats.ErrBadInboxPrefix {
+		t.Fatalf("Expected %v, got %v", nats.ErrBadInboxPrefix, err)
+	}
+	opts := nats.GetDefaultOptions()
+	opts.InboxPrefix = "_SVC..acme"
+	if _, err := opts.Connect(); err != nats.ErrBadInboxPrefix {
+		t.Fatalf("Expected %v, got %v", nats.ErrBadInboxPrefix, err)
+	}
+
+	// Responders check that the inbox honors the prefix.
+	rc := NewDefaultConnection(t)
+	defer rc.Close()
+	var prefix atomic.Value
+	rc.Subscribe("help", func(m *nats.Msg) {
+		if pre := prefix.Load().(string); !strings.HasPrefix(m.Reply, pre+".") {
+			m.Respond([]byte(fmt.Sprintf("%q is not in %q", m.Reply, pre)))
+			return
+		}
+		m.Respond([]byte(`"ok"`))
+	})
+	inboxes, err := rc.SubscribeSync("_INBOX.>")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	rc.Flush()
+
+	// A prefix that could be mistaken for a format string is taken as is.
+	for _, pre := range []string{"_SVC.acme", "_R%s"} {
+		prefix.Store(pre)
+		for _, old := range []bool{false, true} {
+			opts := []nats.Option{nats.CustomInboxPrefix(pre)}
+			if old {
+				opts = append(opts, nats.UseOldRequestStyle())
+			}
+			nc, err := nats.Connect(nats.DefaultURL, opts...)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			defer nc.Close()
+
+			if inbox := nc.NewInbox(); !strings.HasPrefix(inbox, pre+".") {
+				t.Fatalf("Expected inbox with prefix %q, got %q", pre, inbox)
+			}
+			for i := 0; i < 2; i++ {
+				m, err := nc.Request("help", nil, time.Second)
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				if string(m.Data) != `"ok"` {
+					t.Fatalf("Unexpected response: %s", m.Data)
+				}
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			m, err := nc.RequestWithContext(ctx, "help", nil)
+			cancel()
+			if err != nil || string(m.Data) != `"ok"` {
+				t.Fatalf("Unexpected response: %v, %v", m, err)
+			}
+
+			ec, err := nats.NewEncodedConn(nc, nats.JSON_ENCODER)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			var resp string
+			if err := ec.Request("help", "please", &resp, time.Second); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if resp != "ok" {
+				t.Fatalf("Unexpected response: %q", resp)
+			}
+		}
+	}
+	if m, err := inboxes.NextMsg(100 * time.Millisecond); err != nats.ErrTimeout {
+		t.Fatalf("Expected no message on the default inboxes, got %q", m.Subject)
+	}
+}
+
 func TestSimultaneousRequests(t *testing.T) {
 	s := RunDefaultServer()
 	defer s.Shutdown()
@@ -957,6 +1146,9 @@ func TestNilConnection(t *testing.T) {
 	if err := nc.PublishRequest("foo", "reply", data); err == nil || err != nats.ErrInvalidConnection {
 		t.Fatalf("Expected ErrInvalidConnection error, got %v\n", err)
 	}
+	if err := nc.PublishBatch([]*nats.Msg{{Subject: "foo"}}); err == nil || err != nats.ErrInvalidConnection {
+		t.Fatalf("Expected ErrInvalidConnection error, got %v\n", err)
+	}
 
 	// Subscribe
 	if _, err := nc.Subscribe("foo", nil); err == nil || err != nats.ErrInvalidConnection {
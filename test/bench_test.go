@@ -14,6 +14,7 @@
 package test
 
 import (
+	"fmt"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -41,6 +42,79 @@ func BenchmarkPublishSpeed(b *testing.B) {
 	b.StopTimer()
 }
 
+func BenchmarkPublishSizes(b *testing.B) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+	nc := NewDefaultConnection(b)
+	defer nc.Close()
+
+	for _, size := range []int{16, 1024, 8 * 1024, 64 * 1024, 512 * 1024} {
+		b.Run(fmt.Sprintf("%dB", size), func(b *testing.B) {
+			msg := make([]byte, size)
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if err := nc.Publish("foo", msg); err != nil {
+					b.Fatalf("Error in benchmark during Publish: %v\n", err)
+				}
+			}
+			// Make sure they are all processed.
+			nc.Flush()
+		})
+	}
+}
+
+func BenchmarkPublishBatch(b *testing.B) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+	nc := NewDefaultConnection(b)
+	defer nc.Close()
+
+	for _, n := range []int{1, 10, 100} {
+		b.Run(fmt.Sprintf("%dMsgs", n), func(b *testing.B) {
+			msgs := make([]*nats.Msg, n)
+			for i := range msgs {
+				msgs[i] = &nats.Msg{Subject: "foo", Data: []byte("Hello World")}
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			// Each iteration publishes one message, in batches of n.
+			for i := 0; i < b.N; i += n {
+				if err := nc.PublishBatch(msgs); err != nil {
+					b.Fatalf("Error in benchmark during PublishBatch: %v\n", err)
+				}
+			}
+			// Make sure they are all processed.
+			nc.Flush()
+		})
+	}
+}
+
+func BenchmarkParallelPublish(b *testing.B) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+	nc := NewDefaultConnection(b)
+	defer nc.Close()
+
+	msg := []byte("Hello World")
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := nc.Publish("foo", msg); err != nil {
+				b.Errorf("Error in benchmark during Publish: %v\n", err)
+				return
+			}
+		}
+	})
+	// Make sure they are all processed.
+	nc.Flush()
+}
+
 func BenchmarkPubSubSpeed(b *testing.B) {
 	b.StopTimer()
 	s := RunDefaultServer()
@@ -82,6 +156,50 @@ func BenchmarkPubSubSpeed(b *testing.B) {
 	b.StopTimer()
 }
 
+func BenchmarkPubSubPooled(b *testing.B) {
+	for _, pooled := range []bool{false, true} {
+		b.Run(fmt.Sprintf("Pooled=%v", pooled), func(b *testing.B) {
+			s := RunDefaultServer()
+			defer s.Shutdown()
+			nc := NewDefaultConnection(b)
+			defer nc.Close()
+
+			ch := make(chan bool)
+			received := int32(0)
+			handler := func(m *nats.Msg) {
+				m.Release()
+				if nr := atomic.AddInt32(&received, 1); nr == int32(b.N) {
+					ch <- true
+				}
+			}
+			subscribe := nc.Subscribe
+			if pooled {
+				subscribe = nc.SubscribePooled
+			}
+			sub, err := subscribe("foo", handler)
+			if err != nil {
+				b.Fatalf("Error on subscribe: %v", err)
+			}
+			sub.SetPendingLimits(-1, -1)
+			nc.Flush()
+
+			msg := make([]byte, 128)
+			b.SetBytes(int64(len(msg)))
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if err := nc.Publish("foo", msg); err != nil {
+					b.Fatalf("Error in benchmark during Publish: %v\n", err)
+				}
+			}
+			if err := WaitTime(ch, 10*time.Second); err != nil {
+				b.Fatal("Timed out waiting for messages")
+			}
+		})
+	}
+}
+
 func BenchmarkAsyncSubscriptionCreationSpeed(b *testing.B) {
 	b.StopTimer()
 	s := RunDefaultServer()
@@ -19,8 +19,11 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
+	"os"
+	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
@@ -2537,3 +2540,147 @@ func TestRetryOnFailedConnectWithTLSError(t *testing.T) {
 		t.Fatal("Should have connected")
 	}
 }
+
+// forwardConns copies data between the connections accepted by ln and
+// the server at addr. The returned function drops the current ones.
+func forwardConns(t *testing.T, ln net.Listener, addr string) (func(), *int32) {
+	t.Helper()
+	var (
+		mu    sync.Mutex
+		conns []net.Conn
+		count int32
+	)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&count, 1)
+			mu.Lock()
+			conns = append(conns, c)
+			mu.Unlock()
+			go func(c net.Conn) {
+				defer c.Close()
+				up, err := net.Dial("tcp", addr)
+				if err != nil {
+					return
+				}
+				defer up.Close()
+				go io.Copy(up, c)
+				io.Copy(c, up)
+			}(c)
+		}
+	}()
+	kick := func() {
+		mu.Lock()
+		for _, c := range conns {
+			c.Close()
+		}
+		conns = nil
+		mu.Unlock()
+	}
+	return kick, &count
+}
+
+func TestConnectUnixSocket(t *testing.T) {
+	s := RunServerOnPort(-1)
+	defer s.Shutdown()
+
+	dir, err := ioutil.TempDir("", "nats-unix")
+	if err != nil {
+		t.Fatalf("Error creating dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "nats.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("Error listening on unix socket: %v", err)
+	}
+	defer ln.Close()
+	kick, count := forwardConns(t, ln, s.Addr().String())
+
+	rch := make(chan bool, 1)
+	nc, err := nats.Connect("unix://"+path,
+		nats.ReconnectWait(50*time.Millisecond),
+		nats.ReconnectHandler(func(_ *nats.Conn) { rch <- true }))
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer nc.Close()
+	if servers := nc.Servers(); len(servers) == 0 || servers[0] != "unix://"+path {
+		t.Fatalf("Unexpected servers: %v", servers)
+	}
+	if err := nc.Flush(); err != nil {
+		t.Fatalf("Error on flush: %v", err)
+	}
+
+	// Drop the connection, we should reconnect over the same socket.
+	kick()
+	if err := Wait(rch); err != nil {
+		t.Fatal("Did not reconnect")
+	}
+	if n := atomic.LoadInt32(count); n != 2 {
+		t.Fatalf("Expected 2 connections on the socket, got %d", n)
+	}
+	if err := nc.Flush(); err != nil {
+		t.Fatalf("Error on flush: %v", err)
+	}
+}
+
+type testInProcessServer struct {
+	addr  string
+	count int32
+	mu    sync.Mutex
+	last  net.Conn
+}
+
+func (p *testInProcessServer) InProcessConn() (net.Conn, error) {
+	atomic.AddInt32(&p.count, 1)
+	c, err := net.Dial("tcp", p.addr)
+	if err == nil {
+		p.mu.Lock()
+		p.last = c
+		p.mu.Unlock()
+	}
+	return c, err
+}
+
+func TestConnectInProcessServer(t *testing.T) {
+	s := RunServerOnPort(-1)
+	defer s.Shutdown()
+
+	p := &testInProcessServer{addr: s.Addr().String()}
+	rch := make(chan bool, 1)
+	nc, err := nats.Connect("nats://127.0.0.1:1",
+		nats.InProcessServer(p),
+		nats.ReconnectWait(50*time.Millisecond),
+		nats.ReconnectHandler(func(_ *nats.Conn) { rch <- true }))
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer nc.Close()
+	if servers := nc.Servers(); len(servers) != 1 {
+		t.Fatalf("Expected only the in-process server, got %v", servers)
+	}
+
+	sub, err := nc.SubscribeSync("foo")
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+
+	// Kick the client, it needs to come back through the provider.
+	p.mu.Lock()
+	p.last.Close()
+	p.mu.Unlock()
+	if err := Wait(rch); err != nil {
+		t.Fatal("Did not reconnect")
+	}
+	if n := atomic.LoadInt32(&p.count); n != 2 {
+		t.Fatalf("Expected provider to be used twice, got %d", n)
+	}
+	nc.Publish("foo", []byte("hello"))
+	if _, err := sub.NextMsg(2 * time.Second); err != nil {
+		t.Fatalf("Error receiving message after reconnect: %v", err)
+	}
+}
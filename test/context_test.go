@@ -17,8 +17,10 @@ package test
 
 import (
 	"context"
+	"fmt"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -1139,3 +1141,217 @@ func TestUnsubscribeAndNextMsgWithContext(t *testing.T) {
 	}
 	wg.Wait()
 }
+
+func TestSubscribeWithContext(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	if _, err := nc.SubscribeWithContext(nil, "foo", func(_ *nats.Msg) {}); err != nats.ErrInvalidContext {
+		t.Fatalf("Expected '%v', but got: '%v'", nats.ErrInvalidContext, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sub, err := nc.SubscribeWithContext(ctx, "foo", func(_ *nats.Msg) {})
+	if err != nil {
+		t.Fatalf("Expected to be able to subscribe: %s", err)
+	}
+	qsub, err := nc.QueueSubscribeWithContext(ctx, "foo", "bar", func(_ *nats.Msg) {})
+	if err != nil {
+		t.Fatalf("Expected to be able to subscribe: %s", err)
+	}
+	ssub, err := nc.SubscribeSyncWithContext(ctx, "foo")
+	if err != nil {
+		t.Fatalf("Expected to be able to subscribe: %s", err)
+	}
+	qssub, err := nc.QueueSubscribeSyncWithContext(ctx, "foo", "bar")
+	if err != nil {
+		t.Fatalf("Expected to be able to subscribe: %s", err)
+	}
+	if n := nc.NumSubscriptions(); n != 4 {
+		t.Fatalf("Expected 4 subscriptions, got %d", n)
+	}
+
+	cancel()
+	waitFor(t, time.Second, 15*time.Millisecond, func() error {
+		for _, sub := range []*nats.Subscription{sub, qsub, ssub, qssub} {
+			if sub.IsValid() {
+				return fmt.Errorf("subscription on %q still valid", sub.Subject)
+			}
+		}
+		return nil
+	})
+	if _, err := nc.SubscribeWithContext(ctx, "foo", func(_ *nats.Msg) {}); err != context.Canceled {
+		t.Fatalf("Expected '%v', but got: '%v'", context.Canceled, err)
+	}
+
+	// Drained once done, pending messages are processed.
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	var received int32
+	sub, err = nc.Subscribe("foo", func(_ *nats.Msg) {
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&received, 1)
+	})
+	if err != nil {
+		t.Fatalf("Expected to be able to subscribe: %s", err)
+	}
+	if err := sub.UnsubscribeOnDone(ctx, true); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		nc.Publish("foo", nil)
+	}
+	nc.Flush()
+	cancel()
+	waitFor(t, 2*time.Second, 15*time.Millisecond, func() error {
+		if sub.IsValid() {
+			return fmt.Errorf("subscription still valid")
+		}
+		return nil
+	})
+	if n := atomic.LoadInt32(&received); n != 20 {
+		t.Fatalf("Expected 20 messages, got %d", n)
+	}
+
+	// No error is reported if the subscription goes away along with the
+	// context.
+	errCh := make(chan error, 1)
+	nc.SetErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, err error) {
+		select {
+		case errCh <- err:
+		default:
+		}
+	})
+	for i := 0; i < 100; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		sub, err := nc.SubscribeWithContext(ctx, "foo", func(_ *nats.Msg) {})
+		if err != nil {
+			t.Fatalf("Expected to be able to subscribe: %s", err)
+		}
+		sub.Unsubscribe()
+		cancel()
+	}
+	nc.Flush()
+	select {
+	case err := <-errCh:
+		t.Fatalf("Unexpected error: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestSubscriptionMessages(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	asub, err := nc.Subscribe("foo", func(_ *nats.Msg) {})
+	if err != nil {
+		t.Fatalf("Expected to be able to subscribe: %s", err)
+	}
+	if _, err := asub.Messages(context.Background()); err != nats.ErrSyncSubRequired {
+		t.Fatalf("Expected '%v', but got: '%v'", nats.ErrSyncSubRequired, err)
+	}
+
+	receive := func(t *testing.T, ch <-chan *nats.Msg, expected int) {
+		t.Helper()
+		var count int
+		timeout := time.After(2 * time.Second)
+		for {
+			select {
+			case m, ok := <-ch:
+				if !ok {
+					if count != expected {
+						t.Fatalf("Expected %d messages, got %d", expected, count)
+					}
+					return
+				}
+				if string(m.Data) != fmt.Sprintf("%d", count) {
+					t.Fatalf("Expected message %d, got %q", count, m.Data)
+				}
+				count++
+			case <-timeout:
+				t.Fatalf("Channel not closed, got %d messages", count)
+			}
+		}
+	}
+	publish := func(n int) {
+		for i := 0; i < n; i++ {
+			nc.Publish("foo", []byte(fmt.Sprintf("%d", i)))
+		}
+		nc.Flush()
+	}
+
+	t.Run("unsubscribe", func(t *testing.T) {
+		sub, _ := nc.SubscribeSync("foo")
+		ch, err := sub.Messages(context.Background())
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		publish(10)
+		for i := 0; i < 10; i++ {
+			if m := <-ch; string(m.Data) != fmt.Sprintf("%d", i) {
+				t.Fatalf("Expected message %d, got %q", i, m.Data)
+			}
+		}
+		sub.Unsubscribe()
+		receive(t, ch, 0)
+	})
+
+	t.Run("drain", func(t *testing.T) {
+		sub, _ := nc.SubscribeSync("foo")
+		ch, err := sub.Messages(context.Background())
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		publish(10)
+		sub.Drain()
+		receive(t, ch, 10)
+	})
+
+	t.Run("auto unsubscribe", func(t *testing.T) {
+		sub, _ := nc.SubscribeSync("foo")
+		sub.AutoUnsubscribe(5)
+		ch, err := sub.Messages(context.Background())
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		publish(10)
+		receive(t, ch, 5)
+	})
+
+	t.Run("context", func(t *testing.T) {
+		sub, _ := nc.SubscribeSync("foo")
+		defer sub.Unsubscribe()
+		ctx, cancel := context.WithCancel(context.Background())
+		ch, err := sub.Messages(ctx)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		cancel()
+		receive(t, ch, 0)
+		if !sub.IsValid() {
+			t.Fatal("Expected subscription to remain valid")
+		}
+	})
+
+	t.Run("connection closed", func(t *testing.T) {
+		nc := NewDefaultConnection(t)
+		sub, _ := nc.SubscribeSync("foo")
+		ch, err := sub.Messages(context.Background())
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		nc.Close()
+		receive(t, ch, 0)
+		if _, err := sub.Messages(context.Background()); err != nats.ErrBadSubscription {
+			t.Fatalf("Expected '%v', but got: '%v'", nats.ErrBadSubscription, err)
+		}
+	})
+}
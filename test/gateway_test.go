@@ -0,0 +1,333 @@
+// Copyright 2021 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/gateway"
+)
+
+func waitForNumSubs(t *testing.T, nc *nats.Conn, expected int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if nc.NumSubscriptions() == expected {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Expected %d subscriptions, got %d", expected, nc.NumSubscriptions())
+}
+
+func TestGatewayServerSentEvents(t *testing.T) {
+	s := RunServerOnPort(-1)
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+
+	gw := &gateway.Handler{
+		Conn:             nc,
+		PendingMsgsLimit: 10,
+		Authorize: func(r *http.Request, subjects []string) error {
+			for _, subj := range subjects {
+				if strings.HasPrefix(subj, "secret.") {
+					return errors.New("not allowed")
+				}
+			}
+			return nil
+		},
+	}
+	ts := httptest.NewServer(gw)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/?subject=secret.foo")
+	if err != nil {
+		t.Fatalf("Error on get: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected 403, got %d", resp.StatusCode)
+	}
+	resp, err = http.Get(ts.URL + "/")
+	if err != nil {
+		t.Fatalf("Error on get: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected 400, got %d", resp.StatusCode)
+	}
+
+	q := url.Values{"subject": {"orders.*", "alerts"}}
+	resp, err = http.Get(ts.URL + "/?" + q.Encode())
+	if err != nil {
+		t.Fatalf("Error on get: %v", err)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Unexpected content type %q", ct)
+	}
+	waitForNumSubs(t, nc, 2)
+
+	nc.Publish("orders.new", []byte("one\ntwo"))
+	nc.Publish("other", []byte("ignored"))
+	nc.Publish("alerts", []byte("three"))
+	nc.Flush()
+
+	br := bufio.NewReader(resp.Body)
+	var got []string
+	for len(got) < 7 {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Error reading events: %v", err)
+		}
+		got = append(got, strings.TrimSuffix(line, "\n"))
+	}
+	expected := []string{"event: orders.new", "data: one", "data: two", "", "event: alerts", "data: three", ""}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("Expected line %d to be %q, got %q", i, expected[i], got[i])
+		}
+	}
+
+	// Once the client goes away its subscriptions are removed.
+	resp.Body.Close()
+	waitForNumSubs(t, nc, 0)
+}
+
+func TestGatewayWebSocket(t *testing.T) {
+	s := RunServerOnPort(-1)
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+
+	ts := httptest.NewServer(&gateway.Handler{Conn: nc})
+	defer ts.Close()
+
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Error on dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	conn.Write([]byte("GET /?subject=updates.> HTTP/1.1\r\n" +
+		"Host: localhost\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+		"Sec-WebSocket-Version: 13\r\n\r\n"))
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("Error reading handshake: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Expected 101, got %d", resp.StatusCode)
+	}
+	// Value from RFC 6455 for the key above.
+	if accept := resp.Header.Get("Sec-Websocket-Accept"); accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("Unexpected accept key %q", accept)
+	}
+	waitForNumSubs(t, nc, 1)
+
+	nc.Publish("updates.a", []byte("hello"))
+	nc.Flush()
+
+	var hdr [2]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		t.Fatalf("Error reading frame: %v", err)
+	}
+	if hdr[0] != 0x81 || hdr[1]&0x80 != 0 {
+		t.Fatalf("Unexpected frame header %x", hdr)
+	}
+	payload := make([]byte, hdr[1])
+	if _, err := io.ReadFull(br, payload); err != nil {
+		t.Fatalf("Error reading frame: %v", err)
+	}
+	var m struct {
+		Subject string `json:"subject"`
+		Data    string `json:"data"`
+	}
+	if err := json.Unmarshal(payload, &m); err != nil {
+		t.Fatalf("Error decoding frame: %v", err)
+	}
+	if m.Subject != "updates.a" || m.Data != "hello" {
+		t.Fatalf("Unexpected message: %+v", m)
+	}
+
+	// Send a masked close frame with status 1000.
+	mask := []byte{1, 2, 3, 4}
+	var status [2]byte
+	binary.BigEndian.PutUint16(status[:], 1000)
+	frame := []byte{0x88, 0x80 | 2}
+	frame = append(frame, mask...)
+	frame = append(frame, status[0]^mask[0], status[1]^mask[1])
+	conn.Write(frame)
+
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		t.Fatalf("Error reading close frame: %v", err)
+	}
+	if hdr[0] != 0x88 {
+		t.Fatalf("Expected close frame, got %x", hdr)
+	}
+	waitForNumSubs(t, nc, 0)
+}
+
+func TestGatewaySubscribeErrorWithMessageInFlight(t *testing.T) {
+	s := RunServerOnPort(-1)
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+
+	pc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer pc.Close()
+
+	// Keep messages flowing on the first subject so that its handler
+	// is waiting on the client when a later subject fails.
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			pc.Publish("first", []byte("hello"))
+			pc.Flush()
+		}
+	}()
+
+	ts := httptest.NewServer(&gateway.Handler{Conn: nc})
+	defer ts.Close()
+
+	// The subjects in between give the server time to deliver on the
+	// first subscription before the bad one fails.
+	q := url.Values{"subject": {"first"}}
+	for i := 0; i < 20000; i++ {
+		q.Add("subject", "filler."+strconv.Itoa(i))
+	}
+	q.Add("subject", "bad subject")
+
+	for i := 0; i < 5; i++ {
+		resp, err := http.Get(ts.URL + "/?" + q.Encode())
+		if err != nil {
+			t.Fatalf("Error on get: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("Expected 400, got %d", resp.StatusCode)
+		}
+
+		req, _ := http.NewRequest("GET", ts.URL+"/?"+q.Encode(), nil)
+		req.Header.Set("Upgrade", "websocket")
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		req.Header.Set("Sec-WebSocket-Version", "13")
+		resp, err = http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Error on websocket handshake: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("Expected 400, got %d", resp.StatusCode)
+		}
+	}
+	waitForNumSubs(t, nc, 0)
+}
+
+func TestGatewaySlowClient(t *testing.T) {
+	s := RunServerOnPort(-1)
+	defer s.Shutdown()
+
+	for _, disconnect := range []bool{false, true} {
+		// With a single dispatcher, a client blocking the delivery of its
+		// messages would also block the other subscription.
+		nc, err := nats.Connect(s.ClientURL(), nats.SubDispatchers(1))
+		if err != nil {
+			t.Fatalf("Error connecting to server: %v", err)
+		}
+		defer nc.Close()
+
+		other := make(chan *nats.Msg, 1)
+		if _, err := nc.Subscribe("other", func(m *nats.Msg) { other <- m }); err != nil {
+			t.Fatalf("Error on subscribe: %v", err)
+		}
+
+		ts := httptest.NewServer(&gateway.Handler{Conn: nc, MaxQueued: 4, DisconnectSlow: disconnect})
+		defer ts.Close()
+
+		// The client completes the handshake and never reads again.
+		conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+		if err != nil {
+			t.Fatalf("Error dialing: %v", err)
+		}
+		defer conn.Close()
+		conn.Write([]byte("GET /?subject=slow HTTP/1.1\r\nHost: gateway\r\n" +
+			"Upgrade: websocket\r\nConnection: Upgrade\r\n" +
+			"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n"))
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Fatalf("Error on websocket handshake: %v", err)
+		}
+		if resp.StatusCode != http.StatusSwitchingProtocols {
+			t.Fatalf("Expected 101, got %d", resp.StatusCode)
+		}
+		waitForNumSubs(t, nc, 2)
+
+		// Enough to fill the socket buffers and the queue of the client.
+		data := make([]byte, 64*1024)
+		for i := 0; i < 200; i++ {
+			nc.Publish("slow", data)
+		}
+		nc.Publish("other", []byte("hello"))
+		select {
+		case <-other:
+		case <-time.After(2 * time.Second):
+			t.Fatal("Slow client blocked the delivery of other messages")
+		}
+		if disconnect {
+			waitForNumSubs(t, nc, 1)
+		}
+		nc.Close()
+	}
+}
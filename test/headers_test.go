@@ -57,6 +57,56 @@ func TestBasicHeaders(t *testing.T) {
 	}
 }
 
+func TestHeadersCaseSensitive(t *testing.T) {
+	s := RunServerOnPort(-1)
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+
+	sub, err := nc.SubscribeSync("foo")
+	if err != nil {
+		t.Fatalf("Could not subscribe: %v", err)
+	}
+	m := nats.NewMsg("foo")
+	m.Header.Add("x-lower", "a")
+	m.Header.Add("X-LOWER", "b")
+	m.Header.Add("Multi", "c")
+	m.Header.Add("Multi", "d")
+	if err := nc.PublishMsg(m); err != nil {
+		t.Fatalf("Error on publish: %v", err)
+	}
+	msg, err := sub.NextMsg(time.Second)
+	if err != nil {
+		t.Fatalf("Did not receive message: %v", err)
+	}
+	if !reflect.DeepEqual(msg.Header, m.Header) {
+		t.Fatalf("Expected %v, got %v", m.Header, msg.Header)
+	}
+	if msg.Status() != 0 {
+		t.Fatalf("Unexpected status %d", msg.Status())
+	}
+
+	// Inline status of the server.
+	sub, err = nc.SubscribeSync("reply")
+	if err != nil {
+		t.Fatalf("Could not subscribe: %v", err)
+	}
+	if err := nc.PublishMsg(&nats.Msg{Subject: "nobody", Reply: "reply", Header: nats.Header{"A": {"1"}}}); err != nil {
+		t.Fatalf("Error on publish: %v", err)
+	}
+	msg, err = sub.NextMsg(time.Second)
+	if err != nil {
+		t.Fatalf("Did not receive message: %v", err)
+	}
+	if msg.Status() != 503 {
+		t.Fatalf("Expected status 503, got %d", msg.Status())
+	}
+}
+
 func TestRequestMsg(t *testing.T) {
 	s := RunServerOnPort(-1)
 	defer s.Shutdown()
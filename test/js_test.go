@@ -16,10 +16,10 @@ package test
 import (
 	"context"
 	"crypto/rand"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net"
-	"net/http"
 	"os"
 	"reflect"
 	"strings"
@@ -161,11 +161,21 @@ func TestJetStreamPublish(t *testing.T) {
 	if err == nil || !strings.Contains(err.Error(), "stream does not match") {
 		t.Fatalf("Expected an error, got %v", err)
 	}
+	if !errors.Is(err, nats.ErrStreamMismatch) {
+		t.Fatalf("Expected %v, got %v", nats.ErrStreamMismatch, err)
+	}
 	// Test last sequence expectation.
 	pa, err = js.Publish("foo", msg, nats.ExpectLastSequence(10))
 	if err == nil || !strings.Contains(err.Error(), "wrong last sequence") {
 		t.Fatalf("Expected an error, got %v", err)
 	}
+	var apiErr *nats.APIError
+	if !errors.Is(err, nats.ErrWrongLastSequence) || !errors.As(err, &apiErr) || apiErr.Code != 400 {
+		t.Fatalf("Expected %v, got %v", nats.ErrWrongLastSequence, err)
+	}
+	if errors.Is(err, nats.ErrStreamNotFound) {
+		t.Fatalf("Unexpected match of %v", nats.ErrStreamNotFound)
+	}
 	// Messages should have been rejected.
 	expect(0, 1)
 
@@ -189,11 +199,36 @@ func TestJetStreamPublish(t *testing.T) {
 	}
 	expect(2, 2)
 
+	// Same with the duplicate reported as an error.
+	pa, err = js.Publish("foo", msg, nats.MsgId("ZZZ"), nats.FailOnDuplicate())
+	if err != nats.ErrDuplicateMessage {
+		t.Fatalf("Expected %v, got %v", nats.ErrDuplicateMessage, err)
+	}
+	if pa == nil || pa.Sequence != 2 {
+		t.Fatalf("Expected the ack of sequence 2, got %+v", pa)
+	}
+	paf, err := js.PublishAsync("foo", msg, nats.MsgId("ZZZ"), nats.FailOnDuplicate())
+	if err != nil {
+		t.Fatalf("Unexpected publish error: %v", err)
+	}
+	select {
+	case err := <-paf.Err():
+		if err != nats.ErrDuplicateMessage {
+			t.Fatalf("Expected %v, got %v", nats.ErrDuplicateMessage, err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Did not receive the duplicate error")
+	}
+	expect(2, 2)
+
 	// Now try to send one in with the wrong last msgId.
 	pa, err = js.Publish("foo", msg, nats.ExpectLastMsgId("AAA"))
 	if err == nil || !strings.Contains(err.Error(), "wrong last msg") {
 		t.Fatalf("Expected an error, got %v", err)
 	}
+	if !errors.Is(err, nats.ErrWrongLastMsgId) {
+		t.Fatalf("Expected %v, got %v", nats.ErrWrongLastMsgId, err)
+	}
 	// Make sure expected sequence works.
 	pa, err = js.Publish("foo", msg, nats.ExpectLastSequence(22))
 	if err == nil || !strings.Contains(err.Error(), "wrong last sequence") {
@@ -1812,7 +1847,7 @@ func testJetStreamManagement_GetMsg(t *testing.T, srvs ...*jsServer) {
 
 		// Try to fetch the same message which should be gone.
 		_, err = js.GetMsg("foo", originalSeq)
-		if err == nil || err.Error() != `deleted message` {
+		if err == nil || err.Error() != `nats: deleted message` {
 			t.Errorf("Expected deleted message error, got: %v", err)
 		}
 	})
@@ -1828,7 +1863,7 @@ func testJetStreamManagement_GetMsg(t *testing.T, srvs ...*jsServer) {
 		expectedMap := map[string][]string{
 			"X-Nats-Test-Data": {"A:1"},
 		}
-		if !reflect.DeepEqual(streamMsg.Header, http.Header(expectedMap)) {
+		if !reflect.DeepEqual(streamMsg.Header, nats.Header(expectedMap)) {
 			t.Errorf("Expected %v, got: %v", expectedMap, streamMsg.Header)
 		}
 	})
@@ -3551,7 +3586,7 @@ func TestJetStream_UnsubscribeCloseDrain(t *testing.T) {
 		if err == nil {
 			t.Fatalf("Unexpected success")
 		}
-		if err.Error() != `consumer not found` {
+		if !errors.Is(err, nats.ErrConsumerNotFound) {
 			t.Errorf("Expected consumer not found error, got: %v", err)
 		}
 
@@ -4069,7 +4104,7 @@ func TestJetStream_ClusterPlacement(t *testing.T) {
 			if err == nil {
 				t.Error("Unexpected success creating stream in unknown cluster")
 			}
-			expected := `insufficient resources`
+			expected := `nats: insufficient resources`
 			if err != nil && err.Error() != expected {
 				t.Errorf("Expected %q error, got: %v", expected, err)
 			}
@@ -4238,6 +4273,9 @@ func testJetStreamMirror_Source(t *testing.T, nodes ...*jsServer) {
 		if err.Error() != `nats: stream not found` {
 			t.Fatal("Expected stream not found error")
 		}
+		if !errors.Is(err, nats.ErrStreamNotFound) {
+			t.Fatalf("Expected %v, got %v", nats.ErrStreamNotFound, err)
+		}
 	})
 
 	t.Run("bind to stream with wrong subject fails", func(t *testing.T) {
@@ -5416,3 +5454,536 @@ func TestJetStreamPublishAsyncPerf(t *testing.T) {
 	fmt.Printf("Took %v to send %d msgs\n", tt, toSend)
 	fmt.Printf("%.0f msgs/sec\n\n", float64(toSend)/tt.Seconds())
 }
+
+func TestMigrateOnLameDuck(t *testing.T) {
+	stream := &nats.StreamConfig{Name: "LDM", Subjects: []string{"ldm.>"}, Replicas: 1}
+	withJSClusterAndStream(t, "LDM", 3, stream, func(t *testing.T, _ string, nodes ...*jsServer) {
+		p := newLDMProxy(t, nodes[0].Addr().String())
+		defer p.close()
+
+		rch := make(chan bool, 1)
+		dch := make(chan bool, 1)
+		nc, err := nats.Connect(p.url(),
+			nats.DontRandomize(),
+			nats.MigrateOnLameDuck(),
+			nats.ReconnectHandler(func(_ *nats.Conn) { rch <- true }),
+			nats.DisconnectErrHandler(func(_ *nats.Conn, _ error) { dch <- true }))
+		if err != nil {
+			t.Fatalf("Error connecting: %v", err)
+		}
+		defer nc.Close()
+
+		pnc, err := nats.Connect(nodes[1].ClientURL())
+		if err != nil {
+			t.Fatalf("Error connecting: %v", err)
+		}
+		defer pnc.Close()
+
+		js, err := nc.JetStream()
+		if err != nil {
+			t.Fatalf("Error getting context: %v", err)
+		}
+		push, err := js.SubscribeSync("ldm.push")
+		if err != nil {
+			t.Fatalf("Error on subscribe: %v", err)
+		}
+		pull, err := js.PullSubscribe("ldm.pull", "dur")
+		if err != nil {
+			t.Fatalf("Error on pull subscribe: %v", err)
+		}
+		core, err := nc.SubscribeSync("core")
+		if err != nil {
+			t.Fatalf("Error on subscribe: %v", err)
+		}
+		nc.Flush()
+
+		// Wait for the interest to reach the publisher's server.
+		const total = 500
+		for i := 0; ; i++ {
+			pnc.Publish("core", []byte("0"))
+			if _, err := core.NextMsg(100 * time.Millisecond); err == nil {
+				break
+			} else if i == 20 {
+				t.Fatalf("Error receiving message: %v", err)
+			}
+		}
+
+		// Keep publishing while the connection moves.
+		p.inject("INFO {\"ldm\":true,\"headers\":true,\"max_payload\":1048576}\r\n")
+		for i := 1; i <= total; i++ {
+			pnc.Publish("core", []byte(fmt.Sprintf("%d", i)))
+		}
+		pnc.Flush()
+
+		if err := Wait(rch); err != nil {
+			t.Fatal("Connection did not move to another server")
+		}
+		if url := nc.ConnectedUrl(); url == p.url() {
+			t.Fatalf("Expected to be connected to another server, still on %q", url)
+		}
+		select {
+		case <-dch:
+			t.Fatal("Connection should not have been disconnected")
+		default:
+		}
+
+		// Every message must be received, duplicates are possible.
+		got := make(map[string]struct{}, total)
+		for len(got) < total {
+			m, err := core.NextMsg(2 * time.Second)
+			if err != nil {
+				t.Fatalf("Only received %d messages out of %d: %v", len(got), total, err)
+			}
+			if string(m.Data) != "0" {
+				got[string(m.Data)] = struct{}{}
+			}
+		}
+
+		if _, err := js.Publish("ldm.push", []byte("push")); err != nil {
+			t.Fatalf("Error on publish: %v", err)
+		}
+		if _, err := push.NextMsg(2 * time.Second); err != nil {
+			t.Fatalf("Error receiving push message: %v", err)
+		}
+		if _, err := js.Publish("ldm.pull", []byte("pull")); err != nil {
+			t.Fatalf("Error on publish: %v", err)
+		}
+		msgs, err := pull.Fetch(1)
+		if err != nil || len(msgs) != 1 {
+			t.Fatalf("Error on fetch: %v", err)
+		}
+	})
+}
+
+func TestJetStreamSubscribePauseResume(t *testing.T) {
+	s := RunBasicJetStreamServer()
+	defer s.Shutdown()
+
+	if config := s.JetStreamConfig(); config != nil {
+		defer os.RemoveAll(config.StoreDir)
+	}
+
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer nc.Close()
+
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	_, err = js.AddStream(&nats.StreamConfig{
+		Name:     "TEST",
+		Subjects: []string{"foo"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	const totalMsgs = 16536
+	payload := strings.Repeat("A", 1024)
+	for i := 0; i < totalMsgs; i++ {
+		if _, err := js.PublishAsync("foo", []byte(payload)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case <-js.PublishAsyncComplete():
+	case <-time.After(10 * time.Second):
+		t.Fatal("Did not receive completion signal")
+	}
+
+	t.Run("push", func(t *testing.T) {
+		var received int32
+		sub, err := js.Subscribe("foo", func(m *nats.Msg) {
+			atomic.AddInt32(&received, 1)
+		}, nats.EnableFlowControl(), nats.AckNone())
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		defer sub.Unsubscribe()
+		sub.SetPendingLimits(-1, -1)
+		if err := sub.Pause(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		// Without flow control responses, the server stops short.
+		var pending int
+		waitFor(t, 5*time.Second, 100*time.Millisecond, func() error {
+			msgs, _, _ := sub.Pending()
+			if msgs == 0 || msgs != pending {
+				pending = msgs
+				return fmt.Errorf("pending still growing: %d", msgs)
+			}
+			return nil
+		})
+		// Some may have been delivered before the pause.
+		delivered := atomic.LoadInt32(&received)
+		if pending+int(delivered) >= totalMsgs {
+			t.Fatalf("Expected server to stop sending, got %d messages", pending+int(delivered))
+		}
+		time.Sleep(100 * time.Millisecond)
+		if n := atomic.LoadInt32(&received); n != delivered {
+			t.Fatalf("Expected no message delivered while paused, got %d", n-delivered)
+		}
+
+		if err := sub.Resume(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		waitFor(t, 10*time.Second, 100*time.Millisecond, func() error {
+			if n := atomic.LoadInt32(&received); n != totalMsgs {
+				return fmt.Errorf("received %d messages", n)
+			}
+			return nil
+		})
+	})
+
+	t.Run("pull", func(t *testing.T) {
+		sub, err := js.PullSubscribe("foo", "pull")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		defer sub.Unsubscribe()
+		sub.Pause()
+
+		// Fetching is suspended until resumed.
+		if _, err := sub.Fetch(10, nats.MaxWait(200*time.Millisecond)); err != nats.ErrTimeout {
+			t.Fatalf("Expected %v, got %v", nats.ErrTimeout, err)
+		}
+		info, err := sub.ConsumerInfo()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if info.NumAckPending != 0 || info.NumWaiting != 0 {
+			t.Fatalf("Expected no pull request, got %+v", info)
+		}
+
+		time.AfterFunc(100*time.Millisecond, func() { sub.Resume() })
+		msgs, err := sub.Fetch(10, nats.MaxWait(2*time.Second))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(msgs) != 10 {
+			t.Fatalf("Expected 10 messages, got %d", len(msgs))
+		}
+	})
+}
+
+func TestJetStreamHandleErrors(t *testing.T) {
+	s := RunBasicJetStreamServer()
+	defer s.Shutdown()
+
+	if config := s.JetStreamConfig(); config != nil {
+		defer os.RemoveAll(config.StoreDir)
+	}
+
+	errs := make(chan error, 10)
+	nc, err := nats.Connect(s.ClientURL(), nats.RecoverPanics(),
+		nats.ErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, err error) {
+			errs <- err
+		}))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer nc.Close()
+
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	_, err = js.AddStream(&nats.StreamConfig{
+		Name:     "TEST",
+		Subjects: []string{"foo"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, data := range []string{"ok", "retry", "term", "panic"} {
+		if _, err := js.Publish("foo", []byte(data)); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	var mu sync.Mutex
+	deliveries := make(map[string]int)
+	_, err = js.Subscribe("foo", nats.HandleErrors(func(m *nats.Msg) error {
+		mu.Lock()
+		deliveries[string(m.Data)]++
+		n := deliveries[string(m.Data)]
+		mu.Unlock()
+
+		switch string(m.Data) {
+		case "retry":
+			if n == 1 {
+				return errors.New("try again")
+			}
+		case "term":
+			return fmt.Errorf("%w: cannot be handled", nats.ErrTerminate)
+		case "panic":
+			if n == 1 {
+				panic("kaboom")
+			}
+		}
+		return nil
+	}))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Errors are Nak'ed and redelivered, except for the terminated one.
+	expected := map[string]int{"ok": 1, "retry": 2, "term": 1, "panic": 2}
+	check := func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		if !reflect.DeepEqual(deliveries, expected) {
+			return fmt.Errorf("Expected deliveries %v, got %v", expected, deliveries)
+		}
+		return nil
+	}
+	waitFor(t, 2*time.Second, 15*time.Millisecond, check)
+	time.Sleep(250 * time.Millisecond)
+	if err := check(); err != nil {
+		t.Fatal(err)
+	}
+
+	var handlerErrs, panics int
+	for i := 0; i < 3; i++ {
+		select {
+		case err := <-errs:
+			var perr *nats.PanicError
+			var herr *nats.HandlerError
+			switch {
+			case errors.As(err, &perr):
+				panics++
+			case errors.As(err, &herr):
+				handlerErrs++
+			default:
+				t.Fatalf("Unexpected error: %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected errors to be reported")
+		}
+	}
+	if handlerErrs != 2 || panics != 1 {
+		t.Fatalf("Expected 2 handler errors and 1 panic, got %d and %d", handlerErrs, panics)
+	}
+}
+
+func TestJetStreamNamespace(t *testing.T) {
+	s := RunBasicJetStreamServer()
+	defer s.Shutdown()
+
+	if config := s.JetStreamConfig(); config != nil {
+		defer os.RemoveAll(config.StoreDir)
+	}
+
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer nc.Close()
+
+	acme, err := nc.WithNamespace("tenant.acme")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	js, err := acme.JetStream()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// Streams are not namespaced.
+	_, err = js.AddStream(&nats.StreamConfig{
+		Name:     "ACME",
+		Subjects: []string{"tenant.acme.orders.>"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Inboxes, including the ones of the responses, are in the namespace.
+	inboxes, err := nc.SubscribeSync("_INBOX.>")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := js.Publish("orders.new", []byte("1")); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := js.PublishAsync("orders.new", []byte("2")); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	select {
+	case <-js.PublishAsyncComplete():
+	case <-time.After(time.Second):
+		t.Fatal("Did not receive completion signal")
+	}
+	if _, err := js.Publish("orders.*", nil); err != nats.ErrBadSubject {
+		t.Fatalf("Expected %v, got %v", nats.ErrBadSubject, err)
+	}
+
+	si, err := js.StreamInfo("ACME")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if si.State.Msgs != 2 {
+		t.Fatalf("Expected 2 messages, got %d", si.State.Msgs)
+	}
+	m, err := js.GetMsg("ACME", 1)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if m.Subject != "tenant.acme.orders.new" {
+		t.Fatalf("Unexpected subject %q", m.Subject)
+	}
+
+	t.Run("push", func(t *testing.T) {
+		sub, err := js.SubscribeSync("orders.*")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		defer sub.Unsubscribe()
+		ci, err := sub.ConsumerInfo()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if ci.Config.FilterSubject != "tenant.acme.orders.*" ||
+			!strings.HasPrefix(ci.Config.DeliverSubject, "tenant.acme._INBOX.") {
+			t.Fatalf("Unexpected consumer config %+v", ci.Config)
+		}
+		for i := 0; i < 2; i++ {
+			m, err := sub.NextMsg(time.Second)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if m.Subject != "orders.new" {
+				t.Fatalf("Unexpected subject %q", m.Subject)
+			}
+			m.Ack()
+		}
+	})
+
+	t.Run("pull", func(t *testing.T) {
+		sub, err := js.PullSubscribe("orders.new", "pull")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		for _, batch := range []int{1, 5} {
+			msgs, err := sub.Fetch(batch)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			for _, m := range msgs {
+				if m.Subject != "orders.new" {
+					t.Fatalf("Unexpected subject %q", m.Subject)
+				}
+				m.Ack()
+			}
+		}
+	})
+
+	if m, err := inboxes.NextMsg(100 * time.Millisecond); err != nats.ErrTimeout {
+		t.Fatalf("Expected no message on the connection inboxes, got %q", m.Subject)
+	}
+}
+
+func TestJetStreamCustomInboxPrefix(t *testing.T) {
+	s := RunBasicJetStreamServer()
+	defer s.Shutdown()
+
+	if config := s.JetStreamConfig(); config != nil {
+		defer os.RemoveAll(config.StoreDir)
+	}
+
+	nc, err := nats.Connect(s.ClientURL(), nats.CustomInboxPrefix("_SVC.acme"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer nc.Close()
+
+	// Nothing is to be received on the default inboxes.
+	inboxes, err := nc.SubscribeSync("_INBOX.>")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, err = js.AddStream(&nats.StreamConfig{
+		Name:     "TEST",
+		Subjects: []string{"foo"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if _, err := js.Publish("foo", []byte("1")); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		paf, err := js.PublishAsync("foo", []byte("2"))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if reply := paf.Msg().Reply; !strings.HasPrefix(reply, "_SVC.acme.") {
+			t.Fatalf("Unexpected reply subject %q", reply)
+		}
+	}
+	select {
+	case <-js.PublishAsyncComplete():
+	case <-time.After(time.Second):
+		t.Fatal("Did not receive completion signal")
+	}
+
+	t.Run("push", func(t *testing.T) {
+		sub, err := js.SubscribeSync("foo")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		defer sub.Unsubscribe()
+		ci, err := sub.ConsumerInfo()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !strings.HasPrefix(ci.Config.DeliverSubject, "_SVC.acme.") {
+			t.Fatalf("Unexpected deliver subject %q", ci.Config.DeliverSubject)
+		}
+		for i := 0; i < 6; i++ {
+			m, err := sub.NextMsg(time.Second)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if err := m.AckSync(); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+		}
+	})
+
+	t.Run("pull", func(t *testing.T) {
+		sub, err := js.PullSubscribe("foo", "pull")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		var got int
+		for _, batch := range []int{1, 10} {
+			msgs, err := sub.Fetch(batch)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			for _, m := range msgs {
+				m.Ack()
+			}
+			got += len(msgs)
+		}
+		if got != 6 {
+			t.Fatalf("Expected 6 messages, got %d", got)
+		}
+	})
+
+	if m, err := inboxes.NextMsg(100 * time.Millisecond); err != nats.ErrTimeout {
+		t.Fatalf("Expected no message on the default inboxes, got %q", m.Subject)
+	}
+}
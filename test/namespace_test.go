@@ -0,0 +1,177 @@
+// Copyright 2021 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestNamespace(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	for _, prefix := range []string{"", ".", "tenant.*", "tenant.>", "tenant..acme", "tenant acme"} {
+		if _, err := nc.WithNamespace(prefix); err != nats.ErrBadNamespace {
+			t.Fatalf("Expected %v for %q, got %v", nats.ErrBadNamespace, prefix, err)
+		}
+	}
+
+	acme, err := nc.WithNamespace("tenant.acme")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if acme.Prefix() != "tenant.acme" || acme.Conn() != nc {
+		t.Fatalf("Unexpected namespace %q", acme.Prefix())
+	}
+	other, err := nc.WithNamespace("tenant.other.")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	t.Run("subjects", func(t *testing.T) {
+		for _, subj := range []string{"", "orders.", ".orders", "orders..new", "orders.>.new", "orders.new*", "orders.>x"} {
+			if _, err := acme.SubscribeSync(subj); err != nats.ErrBadSubject {
+				t.Fatalf("Expected %v subscribing to %q, got %v", nats.ErrBadSubject, subj, err)
+			}
+		}
+		for _, subj := range []string{"orders.*", "orders.>", ">"} {
+			if err := acme.Publish(subj, nil); err != nats.ErrBadSubject {
+				t.Fatalf("Expected %v publishing to %q, got %v", nats.ErrBadSubject, subj, err)
+			}
+		}
+		if err := acme.PublishRequest("orders", "reply.>", nil); err != nats.ErrBadSubject {
+			t.Fatalf("Expected %v, got %v", nats.ErrBadSubject, err)
+		}
+	})
+
+	t.Run("publish and subscribe", func(t *testing.T) {
+		all, err := acme.SubscribeSync(">")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		defer all.Unsubscribe()
+		ch := make(chan *nats.Msg, 10)
+		orders, err := acme.ChanSubscribe("orders.*", ch)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		defer orders.Unsubscribe()
+		raw, err := nc.SubscribeSync("tenant.>")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		defer raw.Unsubscribe()
+
+		if err := acme.PublishRequest("orders.new", "replies", []byte("1")); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		// Not in the namespace.
+		if err := other.Publish("orders.new", []byte("2")); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if err := nc.Publish("orders.new", []byte("3")); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		m, err := all.NextMsg(time.Second)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if m.Subject != "orders.new" || m.Reply != "tenant.acme.replies" || string(m.Data) != "1" {
+			t.Fatalf("Unexpected message %q %q %q", m.Subject, m.Reply, m.Data)
+		}
+		select {
+		case m := <-ch:
+			if m.Subject != "orders.new" || string(m.Data) != "1" {
+				t.Fatalf("Unexpected message %q %q", m.Subject, m.Data)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Did not receive message")
+		}
+		for _, expected := range []string{"tenant.acme.orders.new", "tenant.other.orders.new"} {
+			m, err := raw.NextMsg(time.Second)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if m.Subject != expected {
+				t.Fatalf("Expected subject %q, got %q", expected, m.Subject)
+			}
+		}
+		if m, err := all.NextMsg(100 * time.Millisecond); err != nats.ErrTimeout {
+			t.Fatalf("Expected only messages of the namespace, got %q", m.Subject)
+		}
+	})
+
+	t.Run("request", func(t *testing.T) {
+		sub, err := acme.Subscribe("service", func(m *nats.Msg) {
+			if !strings.HasPrefix(m.Reply, "tenant.acme._INBOX.") {
+				m.Respond([]byte("inbox not in namespace: " + m.Reply))
+				return
+			}
+			m.Respond([]byte("ok"))
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		defer sub.Unsubscribe()
+
+		resp, err := acme.Request("service", nil, time.Second)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if string(resp.Data) != "ok" || !strings.HasPrefix(resp.Subject, "_INBOX.") {
+			t.Fatalf("Unexpected response %q on %q", resp.Data, resp.Subject)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		resp, err = acme.RequestMsgWithContext(ctx, &nats.Msg{Subject: "service"})
+		if err != nil || string(resp.Data) != "ok" {
+			t.Fatalf("Unexpected response %v, %v", resp, err)
+		}
+		if _, err := other.Request("service", nil, time.Second); err != nats.ErrNoResponders {
+			t.Fatalf("Expected %v, got %v", nats.ErrNoResponders, err)
+		}
+	})
+
+	t.Run("nested", func(t *testing.T) {
+		eu, err := acme.WithNamespace("eu")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if eu.Prefix() != "tenant.acme.eu" {
+			t.Fatalf("Unexpected prefix %q", eu.Prefix())
+		}
+		sub, err := acme.SubscribeSync("eu.>")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		defer sub.Unsubscribe()
+		eu.Publish("orders", nil)
+		m, err := sub.NextMsg(time.Second)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if m.Subject != "eu.orders" {
+			t.Fatalf("Unexpected subject %q", m.Subject)
+		}
+	})
+}
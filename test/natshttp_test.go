@@ -0,0 +1,246 @@
+// Copyright 2021 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	natsserver "github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/natshttp"
+)
+
+func TestNatsHTTPRoundTrip(t *testing.T) {
+	s := RunServerOnPort(-1)
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("X-Method", r.Method)
+		w.Header().Set("X-Host", r.Host)
+		w.Header().Set("X-Echo", r.Header.Get("X-Echo"))
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, "%s?%s:%s", r.URL.Path, r.URL.RawQuery, body)
+	})
+	sub, err := natshttp.Serve(nc, "svc.http", "workers", h)
+	if err != nil {
+		t.Fatalf("Error on serve: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	client := &http.Client{Transport: &natshttp.Transport{Conn: nc, Subject: "svc.http"}}
+
+	req, _ := http.NewRequest("POST", "http://orders.local/v1/orders?id=22", strings.NewReader("hello"))
+	req.Header.Set("X-Echo", "ping")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Error on request: %v", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", resp.StatusCode)
+	}
+	if got := string(body); got != "/v1/orders?id=22:hello" {
+		t.Fatalf("Unexpected body: %q", got)
+	}
+	for k, v := range map[string]string{"X-Method": "POST", "X-Host": "orders.local", "X-Echo": "ping"} {
+		if got := resp.Header.Get(k); got != v {
+			t.Fatalf("Expected header %s to be %q, got %q", k, v, got)
+		}
+	}
+	if got := resp.Header.Get(natshttp.StatusHdr); got != "" {
+		t.Fatalf("Expected internal headers to be removed, got %q", got)
+	}
+
+	resp, err = client.Get("http://orders.local/missing")
+	if err != nil {
+		t.Fatalf("Error on request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expected status 404, got %d", resp.StatusCode)
+	}
+}
+
+func TestNatsHTTPMaxPayload(t *testing.T) {
+	opts := natsserver.DefaultTestOptions
+	opts.Port = -1
+	opts.MaxPayload = 1024
+	s := RunServerWithOptions(opts)
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+
+	sub, err := natshttp.Serve(nc, "svc.http", "", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(bytes.Repeat([]byte("A"), 2048))
+	}))
+	if err != nil {
+		t.Fatalf("Error on serve: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	client := &http.Client{Transport: &natshttp.Transport{Conn: nc, Subject: "svc.http"}}
+
+	// Request body does not fit.
+	_, err = client.Post("http://svc/", "text/plain", bytes.NewReader(make([]byte, 2048)))
+	if err == nil || !errors.Is(err, natshttp.ErrRequestTooLarge) {
+		t.Fatalf("Expected request too large error, got %v", err)
+	}
+
+	// Response body does not fit.
+	resp, err := client.Get("http://svc/")
+	if err != nil {
+		t.Fatalf("Error on request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Fatalf("Expected status 502, got %d", resp.StatusCode)
+	}
+}
+
+func TestNatsHTTPTimeout(t *testing.T) {
+	s := RunServerOnPort(-1)
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	sub, err := natshttp.Serve(nc, "svc.slow", "", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	if err != nil {
+		t.Fatalf("Error on serve: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	tr := &natshttp.Transport{Conn: nc, Subject: "svc.slow", Timeout: 100 * time.Millisecond}
+	client := &http.Client{Transport: tr}
+	start := time.Now()
+	resp, err := client.Get("http://svc/")
+	if err != nil {
+		t.Fatalf("Error on get: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusGatewayTimeout {
+		t.Fatalf("Expected status 504, got %d", resp.StatusCode)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatalf("Request took too long to time out")
+	}
+
+	// The error of the request context is returned as is.
+	tr.Timeout = 0
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://svc/", nil)
+	if _, err := client.Do(req); err == nil || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected deadline exceeded, got %v", err)
+	}
+
+	// Without anyone listening the request fails right away.
+	tr.Subject = "svc.nobody"
+	resp, err = client.Get("http://svc/")
+	if err != nil {
+		t.Fatalf("Error on get: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("Expected status 503, got %d", resp.StatusCode)
+	}
+}
+
+func TestNatsHTTPHandlerPanic(t *testing.T) {
+	s := RunServerOnPort(-1)
+	defer s.Shutdown()
+
+	errCh := make(chan error, 1)
+	nc, err := nats.Connect(s.ClientURL(), nats.ErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, err error) {
+		errCh <- err
+	}))
+	if err != nil {
+		t.Fatalf("Error connecting to server: %v", err)
+	}
+	defer nc.Close()
+
+	sub, err := natshttp.Serve(nc, "svc.panic", "", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/panic" {
+			w.Write([]byte("partial"))
+			panic("boom")
+		}
+		w.Write([]byte("ok"))
+	}))
+	if err != nil {
+		t.Fatalf("Error on serve: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	client := &http.Client{Transport: &natshttp.Transport{Conn: nc, Subject: "svc.panic", Timeout: time.Second}}
+	resp, err := client.Get("http://svc/panic")
+	if err != nil {
+		t.Fatalf("Error on get: %v", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError || strings.Contains(string(body), "partial") {
+		t.Fatalf("Expected status 500, got %d %q", resp.StatusCode, body)
+	}
+	select {
+	case err := <-errCh:
+		var perr *nats.PanicError
+		if !errors.As(err, &perr) || perr.Value != "boom" {
+			t.Fatalf("Expected panic error, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Panic was not reported")
+	}
+
+	// The responder keeps going.
+	resp, err = client.Get("http://svc/")
+	if err != nil {
+		t.Fatalf("Error on get: %v", err)
+	}
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "ok" {
+		t.Fatalf("Unexpected response %d %q", resp.StatusCode, body)
+	}
+}
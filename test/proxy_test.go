@@ -0,0 +1,365 @@
+// Copyright 2021 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// testProxy is a minimal HTTP CONNECT or SOCKS5 proxy that records the
+// targets it was asked to reach.
+type testProxy struct {
+	ln      net.Listener
+	socks   bool
+	user    string
+	pass    string
+	mu      sync.Mutex
+	targets []string
+}
+
+func newTestProxy(t *testing.T, socks bool, user, pass string) *testProxy {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	p := &testProxy{ln: ln, socks: socks, user: user, pass: pass}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go p.handle(c)
+		}
+	}()
+	return p
+}
+
+func (p *testProxy) addr() string {
+	return p.ln.Addr().String()
+}
+
+func (p *testProxy) close() {
+	p.ln.Close()
+}
+
+func (p *testProxy) lastTarget() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.targets) == 0 {
+		return ""
+	}
+	return p.targets[len(p.targets)-1]
+}
+
+func (p *testProxy) handle(c net.Conn) {
+	defer c.Close()
+	br := bufio.NewReader(c)
+	var target string
+	var err error
+	if p.socks {
+		target, err = p.socksHandshake(br, c)
+	} else {
+		target, err = p.connectHandshake(br, c)
+	}
+	if err != nil {
+		return
+	}
+	p.mu.Lock()
+	p.targets = append(p.targets, target)
+	p.mu.Unlock()
+
+	up, err := net.Dial("tcp", target)
+	if err != nil {
+		return
+	}
+	defer up.Close()
+	if p.socks {
+		c.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 0})
+	} else {
+		c.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+	}
+	go io.Copy(up, br)
+	io.Copy(c, up)
+}
+
+func (p *testProxy) connectHandshake(br *bufio.Reader, c net.Conn) (string, error) {
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		return "", err
+	}
+	if req.Method != http.MethodConnect {
+		return "", errors.New("not a connect")
+	}
+	if p.user != "" {
+		expected := "Basic " + base64.StdEncoding.EncodeToString([]byte(p.user+":"+p.pass))
+		if req.Header.Get("Proxy-Authorization") != expected {
+			c.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\n\r\n"))
+			return "", errors.New("bad auth")
+		}
+	}
+	return req.Host, nil
+}
+
+func (p *testProxy) socksHandshake(br *bufio.Reader, c net.Conn) (string, error) {
+	hdr := make([]byte, 2)
+	if _, err := io.ReadFull(br, hdr); err != nil {
+		return "", err
+	}
+	methods := make([]byte, hdr[1])
+	if _, err := io.ReadFull(br, methods); err != nil {
+		return "", err
+	}
+	if p.user != "" {
+		c.Write([]byte{5, 2})
+		ver := make([]byte, 2)
+		io.ReadFull(br, ver)
+		user := make([]byte, ver[1])
+		io.ReadFull(br, user)
+		l := make([]byte, 1)
+		io.ReadFull(br, l)
+		pass := make([]byte, l[0])
+		io.ReadFull(br, pass)
+		if string(user) != p.user || string(pass) != p.pass {
+			c.Write([]byte{1, 1})
+			return "", errors.New("bad auth")
+		}
+		c.Write([]byte{1, 0})
+	} else {
+		c.Write([]byte{5, 0})
+	}
+	req := make([]byte, 4)
+	if _, err := io.ReadFull(br, req); err != nil {
+		return "", err
+	}
+	var host string
+	switch req[3] {
+	case 1:
+		ip := make([]byte, 4)
+		io.ReadFull(br, ip)
+		host = net.IP(ip).String()
+	case 3:
+		l := make([]byte, 1)
+		io.ReadFull(br, l)
+		name := make([]byte, l[0])
+		io.ReadFull(br, name)
+		host = string(name)
+	case 4:
+		ip := make([]byte, 16)
+		io.ReadFull(br, ip)
+		host = net.IP(ip).String()
+	default:
+		return "", errors.New("unsupported address")
+	}
+	port := make([]byte, 2)
+	io.ReadFull(br, port)
+	return net.JoinHostPort(host, strconv.Itoa(int(port[0])<<8|int(port[1]))), nil
+}
+
+func TestProxyHTTPConnect(t *testing.T) {
+	s := RunServerOnPort(-1)
+	defer s.Shutdown()
+
+	p := newTestProxy(t, false, "alice", "s3cr3t")
+	defer p.close()
+
+	// Use a host name, which the proxy needs to get as-is.
+	url := fmt.Sprintf("nats://localhost:%d", s.Addr().(*net.TCPAddr).Port)
+
+	if _, err := nats.Connect(url, nats.ProxyURL("http://alice:wrong@"+p.addr())); err == nil {
+		t.Fatal("Expected error with bad proxy credentials")
+	}
+
+	nc, err := nats.Connect(url, nats.ProxyURL("http://alice:s3cr3t@"+p.addr()))
+	if err != nil {
+		t.Fatalf("Error connecting through proxy: %v", err)
+	}
+	defer nc.Close()
+	if target := p.lastTarget(); target != fmt.Sprintf("localhost:%d", s.Addr().(*net.TCPAddr).Port) {
+		t.Fatalf("Unexpected proxy target %q", target)
+	}
+
+	sub, err := nc.SubscribeSync("foo")
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	nc.Publish("foo", []byte("hello"))
+	if _, err := sub.NextMsg(2 * time.Second); err != nil {
+		t.Fatalf("Error receiving message: %v", err)
+	}
+}
+
+func TestProxySOCKS5(t *testing.T) {
+	s := RunServerOnPort(-1)
+	defer s.Shutdown()
+
+	p := newTestProxy(t, true, "bob", "pa55")
+	defer p.close()
+
+	port := strconv.Itoa(s.Addr().(*net.TCPAddr).Port)
+	url := "nats://localhost:" + port
+	if _, err := nats.Connect(url, nats.ProxyURL("socks5://bob:bad@"+p.addr())); err == nil {
+		t.Fatal("Expected error with bad proxy credentials")
+	}
+
+	// With socks5 the host name is resolved locally, with socks5h by the
+	// proxy.
+	for _, scheme := range []string{"socks5", "socks5h"} {
+		nc, err := nats.Connect(url, nats.ProxyURL(scheme+"://bob:pa55@"+p.addr()))
+		if err != nil {
+			t.Fatalf("Error connecting through proxy: %v", err)
+		}
+		if err := nc.Flush(); err != nil {
+			t.Fatalf("Error on flush: %v", err)
+		}
+		nc.Close()
+		host, tport, err := net.SplitHostPort(p.lastTarget())
+		if err != nil || tport != port {
+			t.Fatalf("Unexpected proxy target %q", p.lastTarget())
+		}
+		if ip := net.ParseIP(host); scheme == "socks5" && (ip == nil || !ip.IsLoopback()) {
+			t.Fatalf("Expected an address as proxy target, got %q", host)
+		} else if scheme == "socks5h" && host != "localhost" {
+			t.Fatalf("Expected the host name as proxy target, got %q", host)
+		}
+	}
+}
+
+func TestProxyHTTPS(t *testing.T) {
+	s := RunServerOnPort(-1)
+	defer s.Shutdown()
+
+	var mu sync.Mutex
+	var target string
+	ps := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "not a connect", http.StatusMethodNotAllowed)
+			return
+		}
+		mu.Lock()
+		target = r.Host
+		mu.Unlock()
+		up, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer up.Close()
+		c, brw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		brw.WriteString("HTTP/1.1 200 Connection established\r\n\r\n")
+		brw.Flush()
+		go io.Copy(up, brw)
+		io.Copy(c, up)
+	}))
+	defer ps.Close()
+
+	proxyURL := "https://" + ps.Listener.Addr().String()
+	url := fmt.Sprintf("nats://localhost:%d", s.Addr().(*net.TCPAddr).Port)
+
+	// The certificate of the proxy has to be trusted.
+	if _, err := nats.Connect(url, nats.ProxyURL(proxyURL)); err == nil {
+		t.Fatal("Expected error with an unknown proxy certificate")
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ps.Certificate())
+	nc, err := nats.Connect(url, nats.ProxyURL(proxyURL), nats.ProxyTLSConfig(&tls.Config{RootCAs: roots}))
+	if err != nil {
+		t.Fatalf("Error connecting through proxy: %v", err)
+	}
+	defer nc.Close()
+	if err := nc.Flush(); err != nil {
+		t.Fatalf("Error on flush: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if target != fmt.Sprintf("localhost:%d", s.Addr().(*net.TCPAddr).Port) {
+		t.Fatalf("Unexpected proxy target %q", target)
+	}
+}
+
+func TestProxyFromEnvironment(t *testing.T) {
+	s := RunServerOnPort(-1)
+	defer s.Shutdown()
+
+	p := newTestProxy(t, false, "", "")
+	defer p.close()
+
+	for _, k := range []string{"HTTPS_PROXY", "https_proxy", "ALL_PROXY", "all_proxy", "NO_PROXY", "no_proxy"} {
+		if v, ok := os.LookupEnv(k); ok {
+			defer os.Setenv(k, v)
+			os.Unsetenv(k)
+		}
+	}
+	os.Setenv("ALL_PROXY", p.addr())
+	defer os.Unsetenv("ALL_PROXY")
+
+	// Environment is only honored when asked for.
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	nc.Close()
+	if target := p.lastTarget(); target != "" {
+		t.Fatalf("Did not expect the proxy to be used, got %q", target)
+	}
+
+	nc, err = nats.Connect(s.ClientURL(), nats.ProxyFromEnvironment())
+	if err != nil {
+		t.Fatalf("Error connecting through proxy: %v", err)
+	}
+	nc.Close()
+	if target := p.lastTarget(); target == "" {
+		t.Fatal("Expected the proxy to be used")
+	}
+
+	os.Setenv("NO_PROXY", "127.0.0.1")
+	defer os.Unsetenv("NO_PROXY")
+	p.mu.Lock()
+	p.targets = nil
+	p.mu.Unlock()
+	nc, err = nats.Connect(s.ClientURL(), nats.ProxyFromEnvironment())
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	nc.Close()
+	if target := p.lastTarget(); target != "" {
+		t.Fatalf("Did not expect the proxy to be used, got %q", target)
+	}
+
+	if _, err := nats.Connect(s.ClientURL(), nats.ProxyURL("ftp://foo")); err == nil || !errors.Is(err, nats.ErrBadProxyURL) {
+		t.Fatalf("Expected bad proxy url error, got %v", err)
+	}
+}
@@ -783,3 +783,73 @@ func TestConnCloseNoCallback(t *testing.T) {
 		t.Fatalf("%s issued a callback and it shouldn't have", what)
 	}
 }
+
+type recordingStrategy struct {
+	mu     sync.Mutex
+	inner  nats.ReconnectStrategy
+	states []nats.ReconnectState
+}
+
+func (r *recordingStrategy) Next(state *nats.ReconnectState) (int, time.Duration) {
+	r.mu.Lock()
+	s := *state
+	s.Servers = append([]nats.ServerStats(nil), state.Servers...)
+	r.states = append(r.states, s)
+	r.mu.Unlock()
+	return r.inner.Next(state)
+}
+
+func TestReconnectStrategy(t *testing.T) {
+	s1 := RunServerOnPort(-1)
+	defer s1.Shutdown()
+	s2 := RunServerOnPort(-1)
+	defer s2.Shutdown()
+
+	rs := &recordingStrategy{inner: &nats.CircuitBreaker{
+		Strategy:  &nats.LowestRTTFirst{Wait: 20 * time.Millisecond},
+		Threshold: 2,
+		Cooldown:  time.Minute,
+	}}
+	rch := make(chan bool, 1)
+	nc, err := nats.Connect(s1.ClientURL(),
+		nats.DontRandomize(),
+		nats.SetReconnectStrategy(rs),
+		nats.ReconnectHandler(func(_ *nats.Conn) { rch <- true }))
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer nc.Close()
+	if err := nc.SetServers([]string{s1.ClientURL(), s2.ClientURL(), "nats://127.0.0.1:1"}); err != nil {
+		t.Fatalf("Error setting servers: %v", err)
+	}
+
+	stats := nc.ServerStats()
+	for _, st := range stats {
+		if st.URL == s1.ClientURL() && (!st.DidConnect || st.RTT == 0 || st.HandshakeTime == 0) {
+			t.Fatalf("Expected history for the connected server, got %+v", st)
+		}
+	}
+
+	s1.Shutdown()
+	if err := Wait(rch); err != nil {
+		t.Fatal("Did not reconnect")
+	}
+	if nc.ConnectedUrl() != s2.ClientURL() {
+		t.Fatalf("Expected to be connected to %q, got %q", s2.ClientURL(), nc.ConnectedUrl())
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if len(rs.states) == 0 {
+		t.Fatal("Strategy was not consulted")
+	}
+	first := rs.states[0]
+	if first.Attempts != 0 || len(first.Servers) != 3 {
+		t.Fatalf("Unexpected first state: %+v", first)
+	}
+	for _, st := range first.Servers {
+		if st.URL == s1.ClientURL() && (st.LastError == nil || st.LastDisconnect.IsZero()) {
+			t.Fatalf("Expected the disconnect to be recorded, got %+v", st)
+		}
+	}
+}
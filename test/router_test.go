@@ -0,0 +1,151 @@
+// Copyright 2021 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestRouter(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	type call struct {
+		route  string
+		params string
+	}
+	ch := make(chan call, 10)
+	handler := func(route string) nats.RouteHandler {
+		return func(m *nats.Msg, p nats.Params) {
+			ch <- call{route, fmt.Sprint(map[string]string(p))}
+		}
+	}
+
+	r := nats.NewRouter(nc)
+	var mu sync.Mutex
+	var trace []string
+	logger := func(name string) nats.Middleware {
+		return func(next nats.RouteHandler) nats.RouteHandler {
+			return func(m *nats.Msg, p nats.Params) {
+				mu.Lock()
+				trace = append(trace, name+" "+m.Subject)
+				mu.Unlock()
+				next(m, p)
+			}
+		}
+	}
+	r.Use(logger("root"))
+
+	for _, rt := range []string{
+		"orders.{region}.{id}.created",
+		"orders.{region}.{id}.deleted",
+		"orders.{region}.summary",
+		"logs.{path>}",
+		"ping",
+	} {
+		if err := r.Handle(rt, handler(rt)); err != nil {
+			t.Fatalf("Error registering %q: %v", rt, err)
+		}
+	}
+	g := r.Group("tenants.{tenant}", logger("group"))
+	if err := g.QueueHandle("users.{id}", "workers", handler("tenants users")); err != nil {
+		t.Fatalf("Error registering group route: %v", err)
+	}
+
+	for _, test := range []struct {
+		pattern string
+		err     error
+	}{
+		{"orders.{r}.{i}.created", nats.ErrRouteConflict},
+		{"orders.eu.*.deleted", nats.ErrRouteConflict},
+		{"orders.>", nats.ErrRouteConflict},
+		{"logs.app", nats.ErrRouteConflict},
+		{"tenants.acme.users.1", nats.ErrRouteConflict},
+		{"orders.{id}.{id}", nats.ErrBadPattern},
+		{"orders.{rest>}.x", nats.ErrBadPattern},
+		{"orders.>.x", nats.ErrBadPattern},
+		{"orders..x", nats.ErrBadPattern},
+		{"orders.{}", nats.ErrBadPattern},
+		{"orders.a{b}", nats.ErrBadPattern},
+	} {
+		if err := r.Handle(test.pattern, handler(test.pattern)); !errors.Is(err, test.err) {
+			t.Fatalf("Expected %v registering %q, got %v", test.err, test.pattern, err)
+		}
+	}
+	// Routes that cannot match the same subjects are fine.
+	for _, rt := range []string{"orders.{region}.{id}.created.v2", "orders", "tenants.{tenant}"} {
+		if err := r.Handle(rt, handler(rt)); err != nil {
+			t.Fatalf("Error registering %q: %v", rt, err)
+		}
+	}
+	if n := nc.NumSubscriptions(); n != 9 {
+		t.Fatalf("Expected 9 subscriptions, got %d", n)
+	}
+
+	for _, test := range []struct {
+		subject string
+		expect  call
+	}{
+		{"orders.eu.42.created", call{"orders.{region}.{id}.created", "map[id:42 region:eu]"}},
+		{"orders.us.7.deleted", call{"orders.{region}.{id}.deleted", "map[id:7 region:us]"}},
+		{"orders.us.summary", call{"orders.{region}.summary", "map[region:us]"}},
+		{"logs.app.db.slow", call{"logs.{path>}", "map[path:app.db.slow]"}},
+		{"ping", call{"ping", "map[]"}},
+		{"tenants.acme.users.bob", call{"tenants users", "map[id:bob tenant:acme]"}},
+	} {
+		nc.Publish(test.subject, nil)
+		select {
+		case c := <-ch:
+			if c != test.expect {
+				t.Fatalf("Expected %+v for %q, got %+v", test.expect, test.subject, c)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("No route invoked for %q", test.subject)
+		}
+	}
+	nc.Publish("orders.eu.42.shipped", nil)
+	nc.Flush()
+	select {
+	case c := <-ch:
+		t.Fatalf("Unexpected route invoked: %+v", c)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	mu.Lock()
+	if got := strings.Join(trace[len(trace)-2:], ","); got != "root tenants.acme.users.bob,group tenants.acme.users.bob" {
+		t.Fatalf("Unexpected middleware calls: %s", got)
+	}
+	mu.Unlock()
+
+	if err := r.Unsubscribe(); err != nil {
+		t.Fatalf("Error on unsubscribe: %v", err)
+	}
+	if n := nc.NumSubscriptions(); n != 0 {
+		t.Fatalf("Expected no subscription, got %d", n)
+	}
+	// Routes can be registered again.
+	if err := r.Handle("ping", handler("ping")); err != nil {
+		t.Fatalf("Error registering route: %v", err)
+	}
+}
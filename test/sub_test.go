@@ -14,7 +14,12 @@
 package test
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"runtime"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -1572,3 +1577,823 @@ func TestAutoUnsubOnSyncSubCanStillRespond(t *testing.T) {
 		t.Fatalf("Error responding: %v", err)
 	}
 }
+
+func TestSubscribePooled(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(nats.DefaultURL, nats.DebugMsgPool())
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer nc.Close()
+
+	if _, err := nc.SubscribePooled("foo", nil); err != nats.ErrBadSubscription {
+		t.Fatalf("Expected %v, got %v", nats.ErrBadSubscription, err)
+	}
+
+	const total = 1000
+	payload := func(i int) []byte {
+		return bytes.Repeat([]byte{byte('a' + i%26)}, i%200+1)
+	}
+	errCh := make(chan error, 1)
+	report := func(err error) {
+		select {
+		case errCh <- err:
+		default:
+		}
+	}
+	done := make(chan struct{})
+	var count int
+	_, err = nc.SubscribePooled("foo.*", func(m *nats.Msg) {
+		i := count
+		count++
+		if expected := fmt.Sprintf("foo.%d", i%3); m.Subject != expected {
+			report(fmt.Errorf("expected subject %q, got %q", expected, m.Subject))
+		}
+		if !bytes.Equal(m.Data, payload(i)) {
+			report(fmt.Errorf("unexpected payload for message %d: %q", i, m.Data))
+		}
+		data := m.Data
+		m.Release()
+		// Released payloads are poisoned in debug mode.
+		if data[0] == payload(i)[0] {
+			report(fmt.Errorf("expected payload of message %d to be poisoned", i))
+		}
+		if err := m.Respond(nil); err != nats.ErrMsgReleased {
+			report(fmt.Errorf("expected %v, got %v", nats.ErrMsgReleased, err))
+		}
+		if count == total {
+			close(done)
+		}
+	})
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	// Not releasing is fine.
+	qdone := make(chan struct{})
+	var qcount int32
+	_, err = nc.QueueSubscribePooled("foo.*", "bar", func(m *nats.Msg) {
+		if atomic.AddInt32(&qcount, 1) == total {
+			close(qdone)
+		}
+	})
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+
+	for i := 0; i < total; i++ {
+		if err := nc.Publish(fmt.Sprintf("foo.%d", i%3), payload(i)); err != nil {
+			t.Fatalf("Error on publish: %v", err)
+		}
+	}
+	for _, ch := range []chan struct{}{done, qdone} {
+		select {
+		case <-ch:
+		case err := <-errCh:
+			t.Fatal(err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("Did not receive all messages")
+		}
+	}
+	select {
+	case err := <-errCh:
+		t.Fatal(err)
+	default:
+	}
+}
+
+func TestSubDispatchers(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	if _, err := nats.Connect(nats.DefaultURL, nats.SubDispatchers(-1)); err != nats.ErrBadDispatchers {
+		t.Fatalf("Expected %v, got %v", nats.ErrBadDispatchers, err)
+	}
+
+	nc, err := nats.Connect(nats.DefaultURL, nats.SubDispatchers(2))
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer nc.Close()
+
+	const (
+		numSubs = 500
+		total   = 100
+	)
+	errCh := make(chan error, 1)
+	report := func(err error) {
+		select {
+		case errCh <- err:
+		default:
+		}
+	}
+	var wg sync.WaitGroup
+	wg.Add(numSubs)
+	before := runtime.NumGoroutine()
+	for i := 0; i < numSubs; i++ {
+		var count int
+		_, err := nc.Subscribe(fmt.Sprintf("foo.%d", i), func(m *nats.Msg) {
+			if expected := fmt.Sprintf("%d", count); string(m.Data) != expected {
+				report(fmt.Errorf("expected message %q on %q, got %q", expected, m.Subject, m.Data))
+			}
+			count++
+			if count == total {
+				wg.Done()
+			}
+		})
+		if err != nil {
+			t.Fatalf("Error on subscribe: %v", err)
+		}
+	}
+	if n := runtime.NumGoroutine() - before; n > 10 {
+		t.Fatalf("Expected subscriptions to share dispatchers, got %d more Go routines", n)
+	}
+
+	// AutoUnsubscribe still stops the delivery at max.
+	var auto int32
+	sub, err := nc.Subscribe("bar", func(_ *nats.Msg) { atomic.AddInt32(&auto, 1) })
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	if err := sub.AutoUnsubscribe(10); err != nil {
+		t.Fatalf("Error on auto unsubscribe: %v", err)
+	}
+
+	for n := 0; n < total; n++ {
+		for i := 0; i < numSubs; i++ {
+			nc.Publish(fmt.Sprintf("foo.%d", i), []byte(fmt.Sprintf("%d", n)))
+		}
+		nc.Publish("bar", nil)
+	}
+
+	// Barrier fires once everything before it was delivered.
+	bch := make(chan struct{})
+	if err := nc.Barrier(func() { close(bch) }); err != nil {
+		t.Fatalf("Error on barrier: %v", err)
+	}
+	select {
+	case <-bch:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Barrier was not invoked")
+	}
+	wg.Wait()
+	select {
+	case err := <-errCh:
+		t.Fatal(err)
+	default:
+	}
+	if n := atomic.LoadInt32(&auto); n != 10 {
+		t.Fatalf("Expected 10 messages, got %d", n)
+	}
+	if sub.IsValid() {
+		t.Fatal("Expected subscription to be removed")
+	}
+
+	// Handlers can make requests with all dispatchers busy, responses are
+	// not delivered from the pool.
+	rc := NewDefaultConnection(t)
+	defer rc.Close()
+	rc.Subscribe("req", func(m *nats.Msg) { m.Respond(m.Data) })
+	rc.Flush()
+	rch := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		nc.Subscribe(fmt.Sprintf("call.%d", i), func(m *nats.Msg) {
+			_, err := nc.Request("req", m.Data, 2*time.Second)
+			rch <- err
+		})
+		nc.Publish(fmt.Sprintf("call.%d", i), []byte("hello"))
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-rch:
+			if err != nil {
+				t.Fatalf("Error on request: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Request did not complete")
+		}
+	}
+}
+
+func TestSubDispatchersSlowConsumer(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	ech := make(chan *nats.Subscription, 10)
+	nc, err := nats.Connect(nats.DefaultURL, nats.SubDispatchers(2),
+		nats.ErrorHandler(func(_ *nats.Conn, sub *nats.Subscription, err error) {
+			if err == nats.ErrSlowConsumer {
+				select {
+				case ech <- sub:
+				default:
+				}
+			}
+		}))
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer nc.Close()
+
+	block := make(chan struct{})
+	slow, err := nc.Subscribe("slow", func(_ *nats.Msg) { <-block })
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	slow.SetPendingLimits(10, -1)
+
+	// The other dispatcher keeps delivering to other subscriptions.
+	fch := make(chan struct{}, 100)
+	if _, err := nc.Subscribe("fast", func(_ *nats.Msg) { fch <- struct{}{} }); err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+
+	for i := 0; i < 20; i++ {
+		nc.Publish("slow", nil)
+	}
+	nc.Publish("fast", nil)
+	nc.Flush()
+
+	select {
+	case sub := <-ech:
+		if sub != slow {
+			t.Fatalf("Expected slow consumer on %q, got %q", slow.Subject, sub.Subject)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected a slow consumer error")
+	}
+	select {
+	case <-fch:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Message was not delivered")
+	}
+	if dropped, _ := slow.Dropped(); dropped == 0 {
+		t.Fatal("Expected messages to be dropped")
+	}
+	close(block)
+}
+
+func TestSubDispatchersDrain(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	closed := make(chan struct{})
+	nc, err := nats.Connect(nats.DefaultURL, nats.SubDispatchers(1),
+		nats.ClosedHandler(func(_ *nats.Conn) { close(closed) }))
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer nc.Close()
+
+	const total = 100
+	var received int32
+	for i := 0; i < 4; i++ {
+		_, err := nc.Subscribe("foo", func(_ *nats.Msg) {
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&received, 1)
+		})
+		if err != nil {
+			t.Fatalf("Error on subscribe: %v", err)
+		}
+	}
+	for i := 0; i < total; i++ {
+		nc.Publish("foo", nil)
+	}
+	nc.Flush()
+
+	if err := nc.Drain(); err != nil {
+		t.Fatalf("Error on drain: %v", err)
+	}
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Connection was not closed")
+	}
+	if n := atomic.LoadInt32(&received); n != 4*total {
+		t.Fatalf("Expected %d messages, got %d", 4*total, n)
+	}
+}
+
+func TestMaxPendingRejectNew(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	ech := make(chan *nats.Subscription, 10)
+	nc, err := nats.Connect(nats.DefaultURL, nats.MaxPending(10, 0, nats.RejectNew),
+		nats.ErrorHandler(func(_ *nats.Conn, sub *nats.Subscription, err error) {
+			if err == nats.ErrPendingLimit {
+				ech <- sub
+			}
+		}))
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer nc.Close()
+
+	block := make(chan struct{})
+	var received int32
+	cb := func(_ *nats.Msg) {
+		<-block
+		atomic.AddInt32(&received, 1)
+	}
+	a, err := nc.Subscribe("a", cb)
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	b, err := nc.Subscribe("b", cb)
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+
+	for _, subj := range []string{"a", "b"} {
+		for i := 0; i < 8; i++ {
+			nc.Publish(subj, []byte("hello"))
+		}
+		nc.Flush()
+	}
+
+	select {
+	case sub := <-ech:
+		if sub != b {
+			t.Fatalf("Expected error for %q, got %q", b.Subject, sub.Subject)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected a pending limit error")
+	}
+	if nc.LastError() != nats.ErrPendingLimit {
+		t.Fatalf("Expected last error to be %v, got %v", nats.ErrPendingLimit, nc.LastError())
+	}
+	stats := nc.Stats()
+	if stats.PendingMsgs != 10 || stats.PendingBytes != 50 || stats.PendingRejected != 6 || stats.PendingEvicted != 0 {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+	if dropped, _ := a.Dropped(); dropped != 0 {
+		t.Fatalf("Expected no message dropped for %q, got %d", a.Subject, dropped)
+	}
+	if dropped, _ := b.Dropped(); dropped != 6 {
+		t.Fatalf("Expected 6 messages dropped for %q, got %d", b.Subject, dropped)
+	}
+
+	close(block)
+	waitFor(t, 2*time.Second, 15*time.Millisecond, func() error {
+		if n := atomic.LoadInt32(&received); n != 10 {
+			return fmt.Errorf("received %d messages", n)
+		}
+		if stats := nc.Stats(); stats.PendingMsgs != 0 || stats.PendingBytes != 0 {
+			return fmt.Errorf("still pending: %+v", stats)
+		}
+		return nil
+	})
+}
+
+func TestMaxPendingEvictLargest(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	ech := make(chan *nats.Subscription, 10)
+	nc, err := nats.Connect(nats.DefaultURL, nats.MaxPending(0, 1000, nats.EvictLargest),
+		nats.ErrorHandler(func(_ *nats.Conn, sub *nats.Subscription, err error) {
+			if err == nats.ErrPendingLimit {
+				ech <- sub
+			}
+		}))
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer nc.Close()
+
+	block := make(chan struct{})
+	var mu sync.Mutex
+	received := make(map[string][]string)
+	cb := func(m *nats.Msg) {
+		<-block
+		mu.Lock()
+		received[m.Subject] = append(received[m.Subject], string(m.Data[:1]))
+		mu.Unlock()
+	}
+	a, err := nc.Subscribe("a", cb)
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	b, err := nc.Subscribe("b", cb)
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+
+	payload := func(i int) []byte {
+		return append([]byte{byte('0' + i)}, make([]byte, 99)...)
+	}
+	for i := 0; i < 8; i++ {
+		nc.Publish("a", payload(i))
+	}
+	nc.Flush()
+	for i := 0; i < 4; i++ {
+		nc.Publish("b", payload(i))
+	}
+	nc.Flush()
+
+	// Messages 1 and 2 of "a" made room for those of "b", message 0 being
+	// processed already.
+	select {
+	case sub := <-ech:
+		if sub != a {
+			t.Fatalf("Expected error for %q, got %q", a.Subject, sub.Subject)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected a pending limit error")
+	}
+	stats := nc.Stats()
+	if stats.PendingMsgs != 10 || stats.PendingBytes != 1000 || stats.PendingEvicted != 2 || stats.PendingRejected != 0 {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+	if dropped, _ := a.Dropped(); dropped != 2 {
+		t.Fatalf("Expected 2 messages dropped for %q, got %d", a.Subject, dropped)
+	}
+	if dropped, _ := b.Dropped(); dropped != 0 {
+		t.Fatalf("Expected no message dropped for %q, got %d", b.Subject, dropped)
+	}
+
+	close(block)
+	waitFor(t, 2*time.Second, 15*time.Millisecond, func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(received["a"]) != 6 || len(received["b"]) != 4 {
+			return fmt.Errorf("received %v", received)
+		}
+		return nil
+	})
+	mu.Lock()
+	if got := fmt.Sprint(received["a"]); got != "[0 3 4 5 6 7]" {
+		t.Fatalf("Unexpected messages received on %q: %s", a.Subject, got)
+	}
+	mu.Unlock()
+
+	// A sync subscription has its oldest messages evicted too.
+	nc2, err := nats.Connect(nats.DefaultURL, nats.MaxPending(3, 0, nats.EvictLargest))
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer nc2.Close()
+	sub, err := nc2.SubscribeSync("c")
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		nc2.Publish("c", payload(i))
+	}
+	nc2.Flush()
+	for i := 2; i < 5; i++ {
+		m, err := sub.NextMsg(time.Second)
+		if err != nil {
+			t.Fatalf("Error on next msg: %v", err)
+		}
+		if m.Data[0] != byte('0'+i) {
+			t.Fatalf("Expected message %d, got %q", i, m.Data[:1])
+		}
+	}
+	if stats := nc2.Stats(); stats.PendingMsgs != 0 || stats.PendingEvicted != 2 {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+
+	// A message dropped for the limits of its own subscription does not
+	// evict messages of others.
+	nc3, err := nats.Connect(nats.DefaultURL, nats.MaxPending(3, 0, nats.EvictLargest))
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer nc3.Close()
+	d, err := nc3.SubscribeSync("d")
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	e, err := nc3.SubscribeSync("e")
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	e.SetPendingLimits(1, -1)
+	nc3.Publish("e", payload(0))
+	nc3.Publish("d", payload(0))
+	nc3.Publish("d", payload(1))
+	nc3.Publish("e", payload(1))
+	nc3.Flush()
+	if stats := nc3.Stats(); stats.PendingMsgs != 3 || stats.PendingEvicted != 0 {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+	if n, _, _ := d.Pending(); n != 2 {
+		t.Fatalf("Expected 2 messages pending for %q, got %d", d.Subject, n)
+	}
+	if dropped, _ := e.Dropped(); dropped != 1 {
+		t.Fatalf("Expected 1 message dropped for %q, got %d", e.Subject, dropped)
+	}
+}
+
+func TestSubscriptionPauseResume(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	for _, test := range []struct {
+		name string
+		opts []nats.Option
+	}{
+		{"own go routine", nil},
+		{"shared dispatchers", []nats.Option{nats.SubDispatchers(1)}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			nc, err := nats.Connect(nats.DefaultURL, test.opts...)
+			if err != nil {
+				t.Fatalf("Error connecting: %v", err)
+			}
+			defer nc.Close()
+
+			const total = 10
+			ch := make(chan string, total)
+			sub, err := nc.QueueSubscribe("foo", "bar", func(m *nats.Msg) { ch <- string(m.Data) })
+			if err != nil {
+				t.Fatalf("Error on subscribe: %v", err)
+			}
+			if err := sub.Pause(); err != nil {
+				t.Fatalf("Error on pause: %v", err)
+			}
+			if !sub.IsPaused() {
+				t.Fatal("Expected subscription to be paused")
+			}
+			for i := 0; i < total; i++ {
+				nc.Publish("foo", []byte(fmt.Sprintf("%d", i)))
+			}
+			nc.Flush()
+
+			// Still subscribed, messages are held.
+			select {
+			case m := <-ch:
+				t.Fatalf("Unexpected message while paused: %q", m)
+			case <-time.After(100 * time.Millisecond):
+			}
+			if msgs, _, _ := sub.Pending(); msgs != total {
+				t.Fatalf("Expected %d pending messages, got %d", total, msgs)
+			}
+
+			if err := sub.Resume(); err != nil {
+				t.Fatalf("Error on resume: %v", err)
+			}
+			if sub.IsPaused() {
+				t.Fatal("Expected subscription to be resumed")
+			}
+			for i := 0; i < total; i++ {
+				select {
+				case m := <-ch:
+					if expected := fmt.Sprintf("%d", i); m != expected {
+						t.Fatalf("Expected message %q, got %q", expected, m)
+					}
+				case <-time.After(time.Second):
+					t.Fatal("Did not receive messages after resume")
+				}
+			}
+
+			// Draining a paused subscription delivers what it holds.
+			sub.Pause()
+			nc.Publish("foo", []byte("last"))
+			nc.Flush()
+			if err := sub.Drain(); err != nil {
+				t.Fatalf("Error on drain: %v", err)
+			}
+			select {
+			case m := <-ch:
+				if m != "last" {
+					t.Fatalf("Expected message %q, got %q", "last", m)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("Did not receive message after drain")
+			}
+			waitFor(t, time.Second, 15*time.Millisecond, func() error {
+				if sub.IsValid() {
+					return fmt.Errorf("subscription still valid")
+				}
+				return nil
+			})
+			if err := sub.Pause(); err != nats.ErrBadSubscription {
+				t.Fatalf("Expected %v, got %v", nats.ErrBadSubscription, err)
+			}
+		})
+	}
+
+	nc := NewDefaultConnection(t)
+	defer nc.Close()
+
+	// NextMsg waits for a sync subscription to be resumed.
+	sub, err := nc.SubscribeSync("foo")
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	sub.Pause()
+	nc.Publish("foo", []byte("hello"))
+	nc.Flush()
+	if _, err := sub.NextMsg(100 * time.Millisecond); err != nats.ErrTimeout {
+		t.Fatalf("Expected %v, got %v", nats.ErrTimeout, err)
+	}
+	time.AfterFunc(100*time.Millisecond, func() { sub.Resume() })
+	if m, err := sub.NextMsg(time.Second); err != nil || string(m.Data) != "hello" {
+		t.Fatalf("Unexpected message and error: %v, %v", m, err)
+	}
+	sub.Pause()
+	nc.Publish("foo", []byte("world"))
+	nc.Flush()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := sub.NextMsgWithContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	time.AfterFunc(100*time.Millisecond, func() { sub.Resume() })
+	if m, err := sub.NextMsgWithContext(context.Background()); err != nil || string(m.Data) != "world" {
+		t.Fatalf("Unexpected message and error: %v, %v", m, err)
+	}
+
+	// Closing the connection releases NextMsg.
+	sub.Pause()
+	time.AfterFunc(100*time.Millisecond, nc.Close)
+	if _, err := sub.NextMsg(5 * time.Second); err != nats.ErrConnectionClosed {
+		t.Fatalf("Expected %v, got %v", nats.ErrConnectionClosed, err)
+	}
+
+	nc = NewDefaultConnection(t)
+	defer nc.Close()
+	csub, err := nc.ChanSubscribe("foo", make(chan *nats.Msg, 1))
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	if err := csub.Pause(); err != nats.ErrTypeSubscription {
+		t.Fatalf("Expected %v, got %v", nats.ErrTypeSubscription, err)
+	}
+}
+
+func TestRecoverPanics(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	for _, test := range []struct {
+		name string
+		opts []nats.Option
+	}{
+		{"own go routine", nil},
+		{"shared dispatchers", []nats.Option{nats.SubDispatchers(1)}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ech := make(chan error, 10)
+			opts := append([]nats.Option{nats.RecoverPanics(),
+				nats.ErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, err error) {
+					ech <- err
+				})}, test.opts...)
+			nc, err := nats.Connect(nats.DefaultURL, opts...)
+			if err != nil {
+				t.Fatalf("Error connecting: %v", err)
+			}
+			defer nc.Close()
+
+			ch := make(chan string, 10)
+			sub, err := nc.Subscribe("foo", func(m *nats.Msg) {
+				if string(m.Data) == "boom" {
+					panic("kaboom")
+				}
+				ch <- string(m.Data)
+			})
+			if err != nil {
+				t.Fatalf("Error on subscribe: %v", err)
+			}
+			nc.Publish("foo", []byte("boom"))
+			nc.Publish("foo", []byte("after"))
+
+			select {
+			case err := <-ech:
+				var perr *nats.PanicError
+				if !errors.As(err, &perr) {
+					t.Fatalf("Expected a panic error, got %v", err)
+				}
+				if perr.Value != "kaboom" || string(perr.Msg.Data) != "boom" {
+					t.Fatalf("Unexpected panic error: %+v", perr)
+				}
+				if !strings.Contains(string(perr.Stack), "TestRecoverPanics") {
+					t.Fatalf("Expected stack of the handler, got %s", perr.Stack)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("Expected panic to be reported")
+			}
+			// The subscription keeps going.
+			select {
+			case m := <-ch:
+				if m != "after" {
+					t.Fatalf("Unexpected message %q", m)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("Did not receive message after panic")
+			}
+			if !sub.IsValid() {
+				t.Fatal("Expected subscription to remain valid")
+			}
+		})
+	}
+}
+
+func TestHandleErrors(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	type report struct {
+		sub *nats.Subscription
+		err error
+	}
+	ech := make(chan report, 10)
+	nc, err := nats.Connect(nats.DefaultURL,
+		nats.ErrorHandler(func(_ *nats.Conn, sub *nats.Subscription, err error) {
+			ech <- report{sub, err}
+		}))
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer nc.Close()
+
+	errBad := errors.New("bad payload")
+	sub, err := nc.Subscribe("foo", nats.HandleErrors(func(m *nats.Msg) error {
+		if string(m.Data) == "bad" {
+			return errBad
+		}
+		return nil
+	}))
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	nc.Publish("foo", []byte("good"))
+	nc.Publish("foo", []byte("bad"))
+
+	select {
+	case r := <-ech:
+		if r.sub != sub {
+			t.Fatalf("Expected error for %q, got %v", sub.Subject, r.sub)
+		}
+		var herr *nats.HandlerError
+		if !errors.As(r.err, &herr) || !errors.Is(r.err, errBad) {
+			t.Fatalf("Expected a handler error, got %v", r.err)
+		}
+		if string(herr.Msg.Data) != "bad" {
+			t.Fatalf("Unexpected message %q", herr.Msg.Data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected error to be reported")
+	}
+	select {
+	case r := <-ech:
+		t.Fatalf("Unexpected error: %v", r.err)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestHandlePanics(t *testing.T) {
+	s := RunDefaultServer()
+	defer s.Shutdown()
+
+	ech := make(chan error, 10)
+	nc, err := nats.Connect(nats.DefaultURL,
+		nats.ErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, err error) {
+			ech <- err
+		}))
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer nc.Close()
+
+	ch := make(chan string, 10)
+	// Messages are released before panicking, the error must not
+	// refer to them.
+	_, err = nc.SubscribePooled("foo.*", nats.HandlePanics(func(m *nats.Msg) {
+		data := string(m.Data)
+		m.Release()
+		if data == "boom" {
+			panic("kaboom")
+		}
+		ch <- data
+	}))
+	if err != nil {
+		t.Fatalf("Error on subscribe: %v", err)
+	}
+	nc.Publish("foo.bar", []byte("boom"))
+	nc.Publish("foo.baz", []byte("after"))
+
+	select {
+	case err := <-ech:
+		var perr *nats.PanicError
+		if !errors.As(err, &perr) {
+			t.Fatalf("Expected a panic error, got %v", err)
+		}
+		if perr.Value != "kaboom" || perr.Msg.Subject != "foo.bar" || perr.Msg.Data != nil {
+			t.Fatalf("Unexpected panic error: %+v", perr)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected panic to be reported")
+	}
+	select {
+	case m := <-ch:
+		if m != "after" {
+			t.Fatalf("Unexpected message %q", m)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Did not receive message after panic")
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright 2021 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import (
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// urlParams are the query parameters that can be given at the end of the
+// URLs passed to Connect, on top of the settings of a context file, see
+// OptionsFromContext.
+var urlParams = map[string]func(o *Options, v string) error{
+	"tls": func(o *Options, v string) (err error) {
+		o.Secure, err = strconv.ParseBool(v)
+		return err
+	},
+	"verbose": func(o *Options, v string) (err error) {
+		o.Verbose, err = strconv.ParseBool(v)
+		return err
+	},
+	"pedantic": func(o *Options, v string) (err error) {
+		o.Pedantic, err = strconv.ParseBool(v)
+		return err
+	},
+	"no_randomize": func(o *Options, v string) (err error) {
+		o.NoRandomize, err = strconv.ParseBool(v)
+		return err
+	},
+	"no_echo": func(o *Options, v string) (err error) {
+		o.NoEcho, err = strconv.ParseBool(v)
+		return err
+	},
+	"no_reconnect": func(o *Options, v string) error {
+		no, err := strconv.ParseBool(v)
+		o.AllowReconnect = !no
+		return err
+	},
+	"retry_on_failed_connect": func(o *Options, v string) (err error) {
+		o.RetryOnFailedConnect, err = strconv.ParseBool(v)
+		return err
+	},
+	"max_reconnects": func(o *Options, v string) (err error) {
+		o.MaxReconnect, err = strconv.Atoi(v)
+		return err
+	},
+	"max_pings_out": func(o *Options, v string) (err error) {
+		o.MaxPingsOut, err = strconv.Atoi(v)
+		return err
+	},
+	"reconnect_buf_size": func(o *Options, v string) (err error) {
+		o.ReconnectBufSize, err = strconv.Atoi(v)
+		return err
+	},
+	"reconnect_wait": func(o *Options, v string) (err error) {
+		o.ReconnectWait, err = time.ParseDuration(v)
+		return err
+	},
+	"reconnect_jitter": func(o *Options, v string) (err error) {
+		o.ReconnectJitter, err = time.ParseDuration(v)
+		return err
+	},
+	"ping_interval": func(o *Options, v string) (err error) {
+		o.PingInterval, err = time.ParseDuration(v)
+		return err
+	},
+	"drain_timeout": func(o *Options, v string) (err error) {
+		o.DrainTimeout, err = time.ParseDuration(v)
+		return err
+	},
+}
+
+// urlOptions updates the options with the query parameters at the end of
+// a comma separated list of URLs, as in "nats://a,b?name=svc&tls=true",
+// and returns the list without them. Errors are ConfigError errors.
+func urlOptions(urls string, o *Options) (string, error) {
+	i := strings.IndexByte(urls, '?')
+	if i < 0 {
+		return urls, nil
+	}
+	servers, query := urls[:i], urls[i+1:]
+	c := &connectConfig{src: "url", vals: make(map[string]string)}
+	if strings.Contains(query, "://") {
+		return servers, c.invalid("query", errors.New("parameters must follow the last URL"))
+	}
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return servers, c.invalid("query", err)
+	}
+	// Sorted for errors to be reported the same way every time.
+	params := make([]string, 0, len(values))
+	for p, vs := range values {
+		if len(vs) > 1 {
+			return servers, c.invalid(p, errors.New("set more than once"))
+		}
+		if _, ok := envSettings[p]; ok && p != "url" {
+			c.vals[p] = vs[0]
+			continue
+		}
+		if _, ok := urlParams[p]; !ok {
+			return servers, c.invalid(p, errors.New("unknown parameter"))
+		}
+		params = append(params, p)
+	}
+	sort.Strings(params)
+
+	// Conflicting parameters.
+	if values.Get("tls") != _EMPTY_ {
+		if tls, err := strconv.ParseBool(values.Get("tls")); err == nil && !tls {
+			for _, p := range []string{"ca", "cert", "key"} {
+				if c.vals[p] != _EMPTY_ {
+					return servers, c.invalid(p, errors.New("conflicts with tls=false"))
+				}
+			}
+		}
+	}
+	if values.Get("no_reconnect") != _EMPTY_ {
+		if no, err := strconv.ParseBool(values.Get("no_reconnect")); err == nil && no {
+			for _, p := range []string{"max_reconnects", "reconnect_wait", "retry_on_failed_connect"} {
+				if values.Get(p) != _EMPTY_ {
+					return servers, c.invalid(p, errors.New("conflicts with no_reconnect=true"))
+				}
+			}
+		}
+	}
+
+	for _, p := range params {
+		if err := urlParams[p](o, values.Get(p)); err != nil {
+			return servers, c.invalid(p, err)
+		}
+	}
+	return servers, c.apply(o)
+}
+
+// redactURL returns the URL with the password, or the token, masked.
+func redactURL(u *url.URL) string {
+	if u.User == nil {
+		return u.String()
+	}
+	ru := *u
+	if _, ok := u.User.Password(); ok {
+		ru.User = url.UserPassword(u.User.Username(), "xxxxx")
+	} else {
+		// A user without password is a token.
+		ru.User = url.User("xxxxx")
+	}
+	return ru.String()
+}